@@ -0,0 +1,197 @@
+package bgzf
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/FitrahHaque/Compression-Engine/compressor/flate"
+	"github.com/FitrahHaque/Compression-Engine/compressor/gzip"
+)
+
+const (
+	// MaxBlockSize bounds a whole member, header and trailer included
+	MaxBlockSize = 0x10000
+	// MaxDataSize is the input taken per block, the same as bgzip, leaving
+	// room for the framing when the data does not compress
+	MaxDataSize = 0xff00
+	// headerSize is the fixed gzip header plus XLEN and the BC subfield
+	headerSize = 18
+	// bsizeOffset is where the BC subfield keeps the total block size minus one
+	bsizeOffset = 16
+)
+
+// EOFMarker is the empty block every BGZF file ends with, so a reader can
+// tell a complete file from a truncated one.
+var EOFMarker = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43, 0x02, 0x00,
+	0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+var blockSizeField = [2]byte{'B', 'C'}
+
+type CompressionCore struct {
+	lock               sync.Mutex
+	Writer             *io.PipeWriter
+	Reader             *io.PipeReader
+	pending            []byte
+	btype              uint32
+	level              int
+	index              Index
+	compressedOffset   uint64
+	uncompressedOffset uint64
+	isClosed           bool
+}
+
+type CompressionReader struct {
+	core *CompressionCore
+}
+
+type CompressionWriter struct {
+	core *CompressionCore
+}
+
+func NewCompressionReaderAndWriter(btype uint32, level int) (io.ReadCloser, io.WriteCloser) {
+	newCompressionCore := new(CompressionCore)
+	newCompressionCore.Reader, newCompressionCore.Writer = io.Pipe()
+	newCompressionCore.btype = btype
+	newCompressionCore.level = level
+	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
+	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
+	return newCompressionReader, newCompressionWriter
+}
+
+// Write emits a block each time MaxDataSize bytes are pending. The blocks go
+// straight into the pipe, so the reader has to be drained concurrently.
+func (cw *CompressionWriter) Write(p []byte) (int, error) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return 0, errors.New("cannot write to a closed compression stream")
+	}
+	cw.core.pending = append(cw.core.pending, p...)
+	for len(cw.core.pending) >= MaxDataSize {
+		if err := cw.core.writeBlock(cw.core.pending[:MaxDataSize]); err != nil {
+			return 0, err
+		}
+		cw.core.pending = append(cw.core.pending[:0], cw.core.pending[MaxDataSize:]...)
+	}
+	return len(p), nil
+}
+
+// Flush ends the current block early, so the next Write starts a new one.
+func (cw *CompressionWriter) Flush() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return errors.New("cannot flush a closed compression stream")
+	}
+	if len(cw.core.pending) == 0 {
+		return nil
+	}
+	if err := cw.core.writeBlock(cw.core.pending); err != nil {
+		return err
+	}
+	cw.core.pending = cw.core.pending[:0]
+	return nil
+}
+
+func (cw *CompressionWriter) Close() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return errors.New("compression stream is already closed")
+	}
+	cw.core.isClosed = true
+	if len(cw.core.pending) > 0 {
+		if err := cw.core.writeBlock(cw.core.pending); err != nil {
+			return err
+		}
+		cw.core.pending = nil
+	}
+	if _, err := cw.core.Writer.Write(EOFMarker); err != nil {
+		return err
+	}
+	return cw.core.Writer.Close()
+}
+
+// Index returns the offsets of every block written so far but the first,
+// which always starts at zero.
+func (cw *CompressionWriter) Index() Index {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	return append(Index(nil), cw.core.index...)
+}
+
+func (cr *CompressionReader) Read(p []byte) (int, error) {
+	return cr.core.Reader.Read(p)
+}
+
+func (cr *CompressionReader) Close() error {
+	return cr.core.Reader.Close()
+}
+
+func (core *CompressionCore) writeBlock(data []byte) error {
+	block, err := compressBlock(data, core.btype, core.level)
+	if err != nil {
+		core.Writer.CloseWithError(err)
+		return err
+	}
+	if len(block) > MaxBlockSize {
+		// the data did not compress, stored blocks always fit
+		if block, err = compressBlock(data, 0, core.level); err != nil {
+			core.Writer.CloseWithError(err)
+			return err
+		}
+	}
+	if _, err := core.Writer.Write(block); err != nil {
+		return err
+	}
+	if core.compressedOffset > 0 {
+		core.index = append(core.index, IndexEntry{
+			CompressedOffset:   core.compressedOffset,
+			UncompressedOffset: core.uncompressedOffset,
+		})
+	}
+	core.compressedOffset += uint64(len(block))
+	core.uncompressedOffset += uint64(len(data))
+	return nil
+}
+
+// compressBlock writes data as a gzip member of its own. The BC subfield is
+// written as zero first and patched once the member size is known.
+func compressBlock(data []byte, btype uint32, level int) ([]byte, error) {
+	header := gzip.Header{
+		OS: gzip.OSUnknown,
+		Extra: []gzip.ExtraField{
+			{ID: blockSizeField, Data: []byte{0, 0}},
+		},
+	}
+	flateReader, flateWriter := flate.NewCompressionReaderAndWriter(btype, level, 0)
+	reader, writer := gzip.NewCompressionReaderAndWriter(flateReader, flateWriter, level, header)
+	writeErr := make(chan error, 1)
+	go func() {
+		if _, err := writer.Write(data); err != nil {
+			writer.Close()
+			writeErr <- err
+			return
+		}
+		writeErr <- writer.Close()
+	}()
+	block, err := io.ReadAll(reader)
+	reader.Close()
+	if err := <-writeErr; err != nil {
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(block) < headerSize || block[12] != blockSizeField[0] || block[13] != blockSizeField[1] {
+		return nil, errors.New("gzip member header is not laid out as a bgzf block")
+	}
+	if len(block) <= MaxBlockSize {
+		binary.LittleEndian.PutUint16(block[bsizeOffset:], uint16(len(block)-1))
+	}
+	return block, nil
+}
@@ -0,0 +1,70 @@
+package bgzf
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// IndexEntry is where a block starts, in the file and in the decoded data.
+type IndexEntry struct {
+	CompressedOffset   uint64
+	UncompressedOffset uint64
+}
+
+// Index lists the block starts in file order, leaving out the first block.
+// On disk it is the .gzi layout of bgzip: the number of entries followed by
+// the offset pairs, all little endian uint64.
+type Index []IndexEntry
+
+func (idx Index) WriteTo(w io.Writer) (int64, error) {
+	output := binary.LittleEndian.AppendUint64(nil, uint64(len(idx)))
+	for _, entry := range idx {
+		output = binary.LittleEndian.AppendUint64(output, entry.CompressedOffset)
+		output = binary.LittleEndian.AppendUint64(output, entry.UncompressedOffset)
+	}
+	n, err := w.Write(output)
+	return int64(n), err
+}
+
+func ReadIndex(r io.Reader) (Index, error) {
+	count := make([]byte, 8)
+	if _, err := io.ReadFull(r, count); err != nil {
+		return nil, err
+	}
+	var idx Index
+	entry := make([]byte, 16)
+	for i := binary.LittleEndian.Uint64(count); i > 0; i-- {
+		if _, err := io.ReadFull(r, entry); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		idx = append(idx, IndexEntry{
+			CompressedOffset:   binary.LittleEndian.Uint64(entry[0:8]),
+			UncompressedOffset: binary.LittleEndian.Uint64(entry[8:16]),
+		})
+		if n := len(idx); n > 1 && (idx[n-1].CompressedOffset <= idx[n-2].CompressedOffset || idx[n-1].UncompressedOffset < idx[n-2].UncompressedOffset) {
+			return nil, errors.New("index entries are not in file order")
+		}
+	}
+	return idx, nil
+}
+
+// nearest returns the last entry for which atOrBefore holds, falling back to
+// the start of the file.
+func (idx Index) nearest(atOrBefore func(IndexEntry) bool) IndexEntry {
+	i := sort.Search(len(idx), func(i int) bool { return !atOrBefore(idx[i]) })
+	if i == 0 {
+		return IndexEntry{}
+	}
+	return idx[i-1]
+}
+
+// VirtualOffset packs the file offset of a block and an offset into its
+// decoded data the way BGZF does: the block offset in the upper 48 bits.
+func VirtualOffset(compressedOffset uint64, withinBlock uint16) uint64 {
+	return compressedOffset<<16 | uint64(withinBlock)
+}
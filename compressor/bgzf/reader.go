@@ -0,0 +1,206 @@
+package bgzf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/FitrahHaque/Compression-Engine/compressor/gzip"
+)
+
+type blockInfo struct {
+	IndexEntry
+	size     int
+	dataSize uint64
+}
+
+// Reader gives random access into a BGZF file. A read decodes only the
+// block holding the current offset, finding it through the index and then
+// by walking the block headers, which needs no decoding.
+type Reader struct {
+	file   io.ReadSeeker
+	index  Index
+	block  []byte
+	info   blockInfo
+	offset uint64
+}
+
+// NewReader reads from file, an empty index means every lookup walks the
+// headers from the start of the file.
+func NewReader(file io.ReadSeeker, index Index) *Reader {
+	return &Reader{
+		file:  file,
+		index: index,
+	}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.block == nil || r.offset < r.info.UncompressedOffset || r.offset >= r.info.UncompressedOffset+uint64(len(r.block)) {
+		if err := r.load(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.block[r.offset-r.info.UncompressedOffset:])
+	r.offset += uint64(n)
+	return n, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	var base uint64
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		base = r.offset
+	case io.SeekEnd:
+		last := r.index.nearest(func(IndexEntry) bool { return true })
+		if end, err := r.locate(last, func(blockInfo) bool { return false }); err != io.EOF {
+			return int64(r.offset), err
+		} else {
+			base = end.UncompressedOffset
+		}
+	default:
+		return int64(r.offset), fmt.Errorf("invalid whence %v", whence)
+	}
+	if int64(base)+offset < 0 {
+		return int64(r.offset), errors.New("cannot seek before the start of the file")
+	}
+	r.offset = uint64(int64(base) + offset)
+	return int64(r.offset), nil
+}
+
+// SeekVirtual moves to a virtual offset, which has to name the start of a block.
+func (r *Reader) SeekVirtual(virtualOffset uint64) error {
+	compressedOffset, withinBlock := virtualOffset>>16, virtualOffset&0xffff
+	start := r.index.nearest(func(entry IndexEntry) bool { return entry.CompressedOffset <= compressedOffset })
+	info, err := r.locate(start, func(b blockInfo) bool { return b.CompressedOffset >= compressedOffset })
+	if err == io.EOF && info.CompressedOffset == compressedOffset && withinBlock == 0 {
+		r.offset = info.UncompressedOffset
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.CompressedOffset != compressedOffset || withinBlock > info.dataSize {
+		return fmt.Errorf("virtual offset %v does not point into a block", virtualOffset)
+	}
+	r.offset = info.UncompressedOffset + withinBlock
+	return nil
+}
+
+// VirtualOffset is the virtual offset of the next byte Read returns.
+func (r *Reader) VirtualOffset() (uint64, error) {
+	info := r.info
+	if r.block == nil || r.offset < info.UncompressedOffset || r.offset >= info.UncompressedOffset+info.dataSize {
+		var err error
+		if info, err = r.find(r.offset); err == io.EOF && info.UncompressedOffset == r.offset {
+			return VirtualOffset(info.CompressedOffset, 0), nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return VirtualOffset(info.CompressedOffset, uint16(r.offset-info.UncompressedOffset)), nil
+}
+
+// load decodes the block holding the current offset, carrying on from the
+// current block when that is closer than the nearest index entry.
+func (r *Reader) load() error {
+	info, err := r.find(r.offset)
+	if err != nil {
+		return err
+	}
+	block := make([]byte, info.size)
+	if _, err := r.file.Seek(int64(info.CompressedOffset), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r.file, block); err != nil {
+		return err
+	}
+	if r.block, err = decodeBlock(block); err != nil {
+		r.block = nil
+		return fmt.Errorf("block at %v: %w", info.CompressedOffset, err)
+	}
+	if uint64(len(r.block)) != info.dataSize {
+		r.block = nil
+		return fmt.Errorf("block at %v: size did not match", info.CompressedOffset)
+	}
+	r.info = info
+	return nil
+}
+
+func (r *Reader) find(offset uint64) (blockInfo, error) {
+	start := r.index.nearest(func(entry IndexEntry) bool { return entry.UncompressedOffset <= offset })
+	if end := r.info.UncompressedOffset + uint64(len(r.block)); r.block != nil && end <= offset && end >= start.UncompressedOffset {
+		start = IndexEntry{
+			CompressedOffset:   r.info.CompressedOffset + uint64(r.info.size),
+			UncompressedOffset: end,
+		}
+	}
+	return r.locate(start, func(b blockInfo) bool { return offset < b.UncompressedOffset+b.dataSize })
+}
+
+// locate walks the blocks from start until match holds. At the end of the
+// file it returns io.EOF along with the offsets just past the last block.
+func (r *Reader) locate(start IndexEntry, match func(blockInfo) bool) (blockInfo, error) {
+	info := blockInfo{IndexEntry: start}
+	for {
+		if _, err := r.file.Seek(int64(info.CompressedOffset), io.SeekStart); err != nil {
+			return info, err
+		}
+		if size, err := readBlockSize(r.file); err != nil {
+			return info, err
+		} else {
+			info.size = size
+		}
+		trailer := make([]byte, 4)
+		if _, err := r.file.Seek(int64(info.CompressedOffset)+int64(info.size)-4, io.SeekStart); err != nil {
+			return info, err
+		}
+		if _, err := io.ReadFull(r.file, trailer); err != nil {
+			return info, fmt.Errorf("block at %v is truncated", info.CompressedOffset)
+		}
+		info.dataSize = uint64(binary.LittleEndian.Uint32(trailer))
+		if match(info) {
+			return info, nil
+		}
+		info.CompressedOffset += uint64(info.size)
+		info.UncompressedOffset += info.dataSize
+	}
+}
+
+// readBlockSize reads the BC subfield from the block header under the read
+// offset, io.EOF means there is no block left.
+func readBlockSize(file io.Reader) (int, error) {
+	fixed := make([]byte, 12)
+	if n, err := io.ReadFull(file, fixed); n == 0 && err == io.EOF {
+		return 0, io.EOF
+	} else if err != nil {
+		return 0, errors.New("block header is truncated")
+	}
+	if fixed[0] != 0x1f || fixed[1] != 0x8b || fixed[2] != 8 || fixed[3]&0x04 == 0 {
+		return 0, errors.New("not a bgzf block, the gzip header has no extra field")
+	}
+	extra := make([]byte, binary.LittleEndian.Uint16(fixed[10:12]))
+	if _, err := io.ReadFull(file, extra); err != nil {
+		return 0, errors.New("block header is truncated")
+	}
+	for len(extra) >= 4 {
+		length := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if extra[0] == blockSizeField[0] && extra[1] == blockSizeField[1] && length == 2 && len(extra) >= 6 {
+			return int(binary.LittleEndian.Uint16(extra[4:6])) + 1, nil
+		}
+		extra = extra[min(len(extra), 4+length):]
+	}
+	return 0, errors.New("not a bgzf block, the BC subfield is missing")
+}
+
+// decodeBlock runs one block through the gzip decoder, which checks its CRC.
+func decodeBlock(block []byte) ([]byte, error) {
+	reader, writer := gzip.NewDecompressionReaderAndWriter()
+	go func() {
+		defer writer.Close()
+		io.Copy(writer, bytes.NewReader(block))
+	}()
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -5,10 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"sync"
 
 	"github.com/FitrahHaque/Compression-Engine/compressor/huffman"
-	"github.com/FitrahHaque/Compression-Engine/compressor/lzss"
 )
 
 type TokenKind int
@@ -29,11 +29,11 @@ type Rulebook struct {
 
 type LitLengthCode struct {
 	LitLengthHuffman []huffman.CanonicalHuffman
-	CanonicalRoot    *huffman.CanonicalHuffmanNode
+	Decoder          *huffman.TableDecoder
 }
 type DistanceCode struct {
 	DistanceHuffman []huffman.CanonicalHuffman
-	CanonicalRoot   *huffman.CanonicalHuffmanNode
+	Decoder         *huffman.TableDecoder
 }
 type CodeLengthCode struct {
 	HuffmanLengthCondensed []struct {
@@ -41,7 +41,7 @@ type CodeLengthCode struct {
 		Offset  int
 	}
 	CondensedHuffman []huffman.CanonicalHuffman
-	CanonicalRoot    *huffman.CanonicalHuffmanNode
+	Decoder          *huffman.TableDecoder
 }
 
 type Token struct {
@@ -57,12 +57,15 @@ type Token struct {
 
 var maxAllowedBackwardDistance int = 32768
 var maxAllowedMatchLength int = 258
+var maxStoredBlockLength int = 65535
+var defaultBlockSize int = 65536
+var maxBufferedOutput int = 1 << 20
 var lenAlphabets = Rulebook{
 	Alphabets: map[int]struct {
 		ExtraBits int
 		Base      int
 	}{
-		257: {ExtraBits: 0, Base: 3}, 258: {ExtraBits: 0, Base: 4}, 259: {ExtraBits: 0, Base: 5}, 260: {ExtraBits: 0, Base: 6}, 261: {ExtraBits: 0, Base: 7}, 262: {ExtraBits: 0, Base: 8}, 263: {ExtraBits: 0, Base: 9}, 264: {ExtraBits: 0, Base: 10}, 265: {ExtraBits: 1, Base: 11}, 266: {ExtraBits: 1, Base: 13}, 267: {ExtraBits: 1, Base: 15}, 268: {ExtraBits: 1, Base: 17}, 269: {ExtraBits: 2, Base: 19}, 270: {ExtraBits: 2, Base: 23}, 271: {ExtraBits: 2, Base: 27}, 272: {ExtraBits: 3, Base: 31}, 273: {ExtraBits: 3, Base: 35}, 274: {ExtraBits: 3, Base: 43}, 275: {ExtraBits: 3, Base: 51}, 276: {ExtraBits: 3, Base: 59}, 277: {ExtraBits: 4, Base: 67}, 278: {ExtraBits: 4, Base: 83}, 279: {ExtraBits: 4, Base: 99}, 280: {ExtraBits: 4, Base: 115}, 281: {ExtraBits: 5, Base: 131}, 282: {ExtraBits: 5, Base: 163}, 283: {ExtraBits: 5, Base: 195}, 284: {ExtraBits: 5, Base: 227}, 285: {ExtraBits: 0, Base: 258},
+		257: {ExtraBits: 0, Base: 3}, 258: {ExtraBits: 0, Base: 4}, 259: {ExtraBits: 0, Base: 5}, 260: {ExtraBits: 0, Base: 6}, 261: {ExtraBits: 0, Base: 7}, 262: {ExtraBits: 0, Base: 8}, 263: {ExtraBits: 0, Base: 9}, 264: {ExtraBits: 0, Base: 10}, 265: {ExtraBits: 1, Base: 11}, 266: {ExtraBits: 1, Base: 13}, 267: {ExtraBits: 1, Base: 15}, 268: {ExtraBits: 1, Base: 17}, 269: {ExtraBits: 2, Base: 19}, 270: {ExtraBits: 2, Base: 23}, 271: {ExtraBits: 2, Base: 27}, 272: {ExtraBits: 2, Base: 31}, 273: {ExtraBits: 3, Base: 35}, 274: {ExtraBits: 3, Base: 43}, 275: {ExtraBits: 3, Base: 51}, 276: {ExtraBits: 3, Base: 59}, 277: {ExtraBits: 4, Base: 67}, 278: {ExtraBits: 4, Base: 83}, 279: {ExtraBits: 4, Base: 99}, 280: {ExtraBits: 4, Base: 115}, 281: {ExtraBits: 5, Base: 131}, 282: {ExtraBits: 5, Base: 163}, 283: {ExtraBits: 5, Base: 195}, 284: {ExtraBits: 5, Base: 227}, 285: {ExtraBits: 0, Base: 258},
 	},
 	KeyOrder: []int{
 		257, 258, 259, 260, 261, 262, 263, 264, 265, 266, 267, 268, 269, 270, 271, 272, 273, 274, 275, 276, 277, 278, 279, 280, 281, 282, 283, 284, 285,
@@ -93,6 +96,30 @@ var rleAlphabets = Rulebook{
 	},
 }
 
+var fixedLitLengthHuffmanLengths, fixedDistanceHuffmanLengths = func() ([]int, []int) {
+	litLength := make([]int, 288)
+	for i := range litLength {
+		switch {
+		case i < 144:
+			litLength[i] = 8
+		case i < 256:
+			litLength[i] = 9
+		case i < 280:
+			litLength[i] = 7
+		default:
+			litLength[i] = 8
+		}
+	}
+	distance := make([]int, 32)
+	for i := range distance {
+		distance[i] = 5
+	}
+	return litLength, distance
+}()
+
+var fixedLitLengthHuffman = huffman.AssignCanonicalHuffmanCodes(fixedLitLengthHuffmanLengths)
+var fixedDistanceHuffman = huffman.AssignCanonicalHuffmanCodes(fixedDistanceHuffmanLengths)
+
 type CompressionWriter struct {
 	core *compressionCore
 }
@@ -105,74 +132,161 @@ type bitBuffer struct {
 }
 
 type compressionCore struct {
-	isInputBufferClosed bool
-	cond                *sync.Cond
-	lock                sync.Mutex
-	inputBuffer         io.ReadWriter
-	outputBuffer        io.ReadWriter
-	bitBuffer           *bitBuffer
-	btype               uint32
-	bfinal              uint32
+	isClosed       bool
+	isReaderClosed bool
+	cond           *sync.Cond
+	lock           sync.Mutex
+	pending        []byte
+	window         []byte
+	outputBuffer   *bytes.Buffer
+	bitBuffer      *bitBuffer
+	btype          uint32
+	level          int
+	blockSize      int
+	// rsync is set when blocks also end at content-defined boundaries
+	rsync *rsyncRoller
 }
 
 func (cr *CompressionReader) Read(data []byte) (int, error) {
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
-	for !cr.core.isInputBufferClosed {
+	for cr.core.outputBuffer.Len() == 0 && !cr.core.isClosed {
 		cr.core.cond.Wait()
 	}
+	defer cr.core.cond.Broadcast()
 	return cr.core.outputBuffer.Read(data)
 }
 
 func (cr *CompressionReader) Close() error {
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
-	if buf, ok := cr.core.inputBuffer.(*bytes.Buffer); ok {
-		buf.Reset()
-		cr.core.isInputBufferClosed = false
-		return nil
-	} else {
-		return errors.New("underlying io.ReadWriter is not *bytes.Buffer. Type assertion failed")
-	}
+	cr.core.isReaderClosed = true
+	cr.core.outputBuffer.Reset()
+	cr.core.cond.Broadcast()
+	return nil
 }
 
+// Write compresses a block as soon as more than blockSize bytes are pending
+// and holds back while more than maxBufferedOutput compressed bytes are
+// waiting for the reader, so the reader has to be drained concurrently.
 func (cw *CompressionWriter) Write(data []byte) (int, error) {
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
-	if cw.core.isInputBufferClosed {
-		return 0, errors.New("reading from the original stream for the previous block has not completed yet!")
+	if cw.core.isClosed {
+		return 0, errors.New("cannot write to a closed compression stream")
+	}
+	written := 0
+	for len(data) > 0 {
+		if cw.core.isReaderClosed {
+			return written, io.ErrClosedPipe
+		}
+		n := min(len(data), cw.core.blockSize+1-len(cw.core.pending))
+		boundary := false
+		if cw.core.rsync != nil {
+			if end := cw.core.rsync.roll(data[:n]); end >= 0 {
+				n, boundary = end, true
+			}
+		}
+		cw.core.pending = append(cw.core.pending, data[:n]...)
+		data, written = data[n:], written+n
+		if boundary {
+			// end the block on a byte boundary, once matches stop reaching back
+			// past an edit the blocks after it come out the same as before
+			if err := cw.writeBlock(cw.core.pending, 0); err != nil {
+				return written, err
+			}
+			if err := cw.writeStoredBlock(nil, 0); err != nil {
+				return written, err
+			}
+			cw.core.pending = cw.core.pending[:0]
+			cw.core.cond.Broadcast()
+		} else if len(cw.core.pending) > cw.core.blockSize {
+			if err := cw.writeBlock(cw.core.pending[:cw.core.blockSize], 0); err != nil {
+				return written, err
+			}
+			cw.core.pending = append(cw.core.pending[:0], cw.core.pending[cw.core.blockSize:]...)
+			cw.core.cond.Broadcast()
+		}
+		for cw.core.outputBuffer.Len() > maxBufferedOutput && !cw.core.isReaderClosed {
+			cw.core.cond.Wait()
+		}
 	}
-	// fmt.printf("[ flate.CompressionWriter.Write ] data written to inputBuffer\n")
-	return cw.core.inputBuffer.Write(data)
+	return written, nil
 }
 
-func (cw *CompressionWriter) Close() error {
+// Flush compresses whatever is pending and ends it with an empty stored
+// block, so everything written so far can be decoded by the receiver.
+func (cw *CompressionWriter) Flush() error {
 	cw.core.lock.Lock()
-	originalData, err := io.ReadAll(cw.core.inputBuffer)
-	cw.core.lock.Unlock()
-	// fmt.Printf("[ DecompressionWriter.Close ] compressedData: %v\n", compressedData)
-	if err != nil {
-		return err
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return errors.New("cannot flush a closed compression stream")
 	}
-	if err = cw.compress(originalData); err != nil {
+	if len(cw.core.pending) > 0 {
+		if err := cw.writeBlock(cw.core.pending, 0); err != nil {
+			return err
+		}
+		cw.core.pending = cw.core.pending[:0]
+	}
+	if err := cw.writeStoredBlock(nil, 0); err != nil {
 		return err
-	} else {
-		cw.core.lock.Lock()
-		defer cw.core.lock.Unlock()
+	}
+	cw.core.cond.Broadcast()
+	return nil
+}
 
-		cw.core.isInputBufferClosed = true
-		cw.core.cond.Signal()
-		return nil
+// SetDictionary primes the window with a preset dictionary so the first
+// block can already refer back into it. It must come before any Write.
+func (cw *CompressionWriter) SetDictionary(dictionary []byte) error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed || len(cw.core.pending) > 0 || len(cw.core.window) > 0 {
+		return errors.New("preset dictionary must be set before writing to the compression stream")
+	}
+	cw.slideWindow(dictionary)
+	return nil
+}
+
+// SetRsyncable makes blocks also end where a rolling sum over the input
+// says so, like gzip --rsyncable. It must come before any Write.
+func (cw *CompressionWriter) SetRsyncable(rsyncable bool) error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed || len(cw.core.pending) > 0 || len(cw.core.window) > 0 {
+		return errors.New("rsyncable must be set before writing to the compression stream")
+	}
+	cw.core.rsync = nil
+	if rsyncable {
+		cw.core.rsync = new(rsyncRoller)
 	}
+	return nil
 }
 
-func NewCompressionReaderAndWriter(btype uint32, bfinal uint32) (io.ReadCloser, io.WriteCloser) {
+func (cw *CompressionWriter) Close() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return errors.New("compression stream is already closed")
+	}
+	defer cw.core.cond.Broadcast()
+	cw.core.isClosed = true
+	if err := cw.writeBlock(cw.core.pending, 1); err != nil {
+		return err
+	}
+	cw.core.pending, cw.core.window = nil, nil
+	return cw.flushAlign()
+}
+
+func NewCompressionReaderAndWriter(btype uint32, level int, blockSize int) (io.ReadCloser, io.WriteCloser) {
 	newCompressionCore := new(compressionCore)
-	newCompressionCore.inputBuffer, newCompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
+	newCompressionCore.outputBuffer = new(bytes.Buffer)
 	newCompressionCore.bitBuffer = new(bitBuffer)
-	newCompressionCore.isInputBufferClosed = false
 	newCompressionCore.btype = btype
-	newCompressionCore.bfinal = bfinal
+	newCompressionCore.level = level
+	if blockSize <= 0 {
+		blockSize = defaultBlockSize
+	}
+	newCompressionCore.blockSize = blockSize
 	newCompressionCore.cond = sync.NewCond(&newCompressionCore.lock)
 	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
 	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
@@ -211,6 +325,10 @@ func (dc *DistanceCode) Encode(items any) ([]int, error) {
 			}
 		}
 	}
+	if !slices.ContainsFunc(symbolFreq, func(freq int) bool { return freq > 0 }) {
+		// blocks made only of literals still describe one distance code
+		symbolFreq[0] = 1
+	}
 	if distHuffmanCode, err := huffman.BuildCanonicalHuffmanEncoder(symbolFreq, 15); err != nil {
 		return nil, err
 	} else {
@@ -224,6 +342,21 @@ func (dc *DistanceCode) Encode(items any) ([]int, error) {
 	}
 }
 
+func (dc *DistanceCode) EncodeFixed(tokens []Token) error {
+	for i := range tokens {
+		token := &tokens[i]
+		if token.Kind == MatchToken {
+			if code, offset, err := dc.FindCode(token.Distance); err != nil {
+				return err
+			} else {
+				token.DistanceCode, token.DistanceOffset = code, offset
+			}
+		}
+	}
+	dc.DistanceHuffman = fixedDistanceHuffman
+	return nil
+}
+
 func (llc *LitLengthCode) FindCode(value int) (code int, offset int, err error) {
 	if value < 3 || value > maxAllowedMatchLength {
 		return 0, 0, errors.New("value is out of range to have a match with RFC length code")
@@ -274,6 +407,21 @@ func (llc *LitLengthCode) Encode(items any) ([]int, error) {
 	}
 }
 
+func (llc *LitLengthCode) EncodeFixed(tokens []Token) error {
+	for i := range tokens {
+		token := &tokens[i]
+		if token.Kind == MatchToken {
+			if code, offset, err := llc.FindCode(token.Length); err != nil {
+				return err
+			} else {
+				token.LengthCode, token.LengthOffset = code, offset
+			}
+		}
+	}
+	llc.LitLengthHuffman = fixedLitLengthHuffman
+	return nil
+}
+
 func (clc *CodeLengthCode) FindCode(lengthHuffmanLengths []int) (err error) {
 	countZero, countSame := 0, 0
 	resolveCountZero := func() error {
@@ -436,15 +584,95 @@ func (clc *CodeLengthCode) shuffle(code []huffman.CanonicalHuffman) []huffman.Ca
 	return huffmanLengths
 }
 
-func (cw *CompressionWriter) compress(content []byte) error {
-	contentRune := []rune(string(content))
-	// fmt.printf("[ flate.CompressionWriter.compress ] contentString %v\n", string(content))
-	refChannels := make([]chan lzss.Reference, len(contentRune))
-	lzss.FindMatch(refChannels, contentRune, maxAllowedBackwardDistance, maxAllowedMatchLength)
-	tokens, err := tokeniseLZSS(refChannels)
-	if err != nil {
+func (cw *CompressionWriter) writeBlock(content []byte, bfinal uint32) error {
+	if cw.core.level < 0 || cw.core.level >= len(compressionLevels) {
+		return fmt.Errorf("compression level %v is out of range 0-%v", cw.core.level, len(compressionLevels)-1)
+	}
+	if cw.core.btype == 0 || cw.core.level == 0 {
+		if err := cw.writeStoredBlocks(content, bfinal); err != nil {
+			return err
+		}
+		cw.slideWindow(content)
+		return nil
+	}
+	if cw.core.btype != 1 && cw.core.btype != 2 {
+		return fmt.Errorf("btype %v is not supported for compression", cw.core.btype)
+	}
+	data := append(append([]byte{}, cw.core.window...), content...)
+	tokens := newMatcher(len(data), compressionLevels[cw.core.level]).tokenise(data, len(cw.core.window))
+	cw.slideWindow(content)
+	if cw.core.btype == 1 {
+		return cw.writeFixedBlock(tokens, bfinal)
+	}
+	return cw.writeDynamicBlock(tokens, bfinal)
+}
+
+func (cw *CompressionWriter) slideWindow(content []byte) {
+	if len(content) >= maxAllowedBackwardDistance {
+		cw.core.window = append(cw.core.window[:0], content[len(content)-maxAllowedBackwardDistance:]...)
+		return
+	}
+	cw.core.window = append(cw.core.window, content...)
+	if extra := len(cw.core.window) - maxAllowedBackwardDistance; extra > 0 {
+		cw.core.window = append(cw.core.window[:0], cw.core.window[extra:]...)
+	}
+}
+
+func (cw *CompressionWriter) writeStoredBlocks(content []byte, bfinal uint32) error {
+	for start := 0; start == 0 || start < len(content); start += maxStoredBlockLength {
+		end := min(len(content), start+maxStoredBlockLength)
+		final := uint32(0)
+		if end == len(content) {
+			final = bfinal
+		}
+		if err := cw.writeStoredBlock(content[start:end], final); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cw *CompressionWriter) writeStoredBlock(content []byte, bfinal uint32) error {
+	if len(content) > maxStoredBlockLength {
+		return fmt.Errorf("stored block cannot be longer than %v bytes", maxStoredBlockLength)
+	}
+	if err := cw.writeCompressedContent(bfinal, 1); err != nil {
+		return err
+	}
+	if err := cw.writeCompressedContent(0, 2); err != nil {
 		return err
 	}
+	if err := cw.flushAlign(); err != nil {
+		return err
+	}
+	LEN := uint32(len(content))
+	NLEN := ^LEN & 0xFFFF
+	if err := cw.writeCompressedContent(LEN, 16); err != nil {
+		return err
+	}
+	if err := cw.writeCompressedContent(NLEN, 16); err != nil {
+		return err
+	}
+	_, err := cw.core.outputBuffer.Write(content)
+	return err
+}
+
+func (cw *CompressionWriter) writeFixedBlock(tokens []Token, bfinal uint32) error {
+	newLitLengthCode := new(LitLengthCode)
+	if err := newLitLengthCode.EncodeFixed(tokens); err != nil {
+		return err
+	}
+	newDistanceCode := new(DistanceCode)
+	if err := newDistanceCode.EncodeFixed(tokens); err != nil {
+		return err
+	}
+	cw.writeCompressedContent(bfinal, 1)
+	cw.writeCompressedContent(1, 2)
+	cw.writeTokens(tokens, newLitLengthCode, newDistanceCode)
+	return nil
+}
+
+func (cw *CompressionWriter) writeDynamicBlock(tokens []Token, bfinal uint32) error {
 	newLitLengthCode := new(LitLengthCode)
 	litLenHuffmanLengths, err := newLitLengthCode.Encode(tokens)
 	// fmt.printf("[ flate.CompressionWriter.compress ] len(litLenHuffmanLengths): %v\n", len(litLenHuffmanLengths))
@@ -470,12 +698,10 @@ func (cw *CompressionWriter) compress(content []byte) error {
 	HLIT := len(litLenHuffmanLengths) - 257
 	HDIST := len(distHuffmanLengths) - 1
 	HCLEN := len(codeLengthHuffmanLengths) - 4
-	cw.core.lock.Lock()
-	defer cw.core.lock.Unlock()
-	// fmt.printf("[ flate.CompressionWriter.compress ] bfinal: %v, bits: %v\n", cw.core.bfinal, 1)
-	cw.writeCompressedContent(cw.core.bfinal, 1)
-	// fmt.printf("[ flate.CompressionWriter.compress ] btype: %v, bits: %v\n", cw.core.btype, 2)
-	cw.writeCompressedContent(cw.core.btype, 2)
+	// fmt.printf("[ flate.CompressionWriter.compress ] bfinal: %v, bits: %v\n", bfinal, 1)
+	cw.writeCompressedContent(bfinal, 1)
+	// fmt.printf("[ flate.CompressionWriter.compress ] btype: %v, bits: %v\n", 2, 2)
+	cw.writeCompressedContent(2, 2)
 	// fmt.printf("[ flate.CompressionWriter.compress ] HLIT: %v, bits: %v\n", uint32(HLIT), 5)
 	cw.writeCompressedContent(uint32(HLIT), 5)
 	// fmt.printf("[ flate.CompressionWriter.compress ] HDIST: %v, bits: %v\n", uint32(HDIST), 5)
@@ -500,6 +726,11 @@ func (cw *CompressionWriter) compress(content []byte) error {
 			cw.writeCompressedContent(uint32(code.Offset), uint(rleAlphabets.Alphabets[code.RLECode].ExtraBits))
 		}
 	}
+	cw.writeTokens(tokens, newLitLengthCode, newDistanceCode)
+	return nil
+}
+
+func (cw *CompressionWriter) writeTokens(tokens []Token, newLitLengthCode *LitLengthCode, newDistanceCode *DistanceCode) {
 	for _, token := range tokens {
 		if token.Kind == LiteralToken {
 			litLenHuff := newLitLengthCode.LitLengthHuffman[token.Value]
@@ -525,7 +756,6 @@ func (cw *CompressionWriter) compress(content []byte) error {
 	eobHuff := newLitLengthCode.LitLengthHuffman[256]
 	// fmt.printf("[ flate.CompressionWriter.compress ] EOB: %v --- HuffmanCode: %v, HuffmanCodeLength: %v\n", 256, eobHuff.GetValue(), eobHuff.GetLength())
 	cw.writeCompressedContent(huffman.Reverse(uint32(eobHuff.GetValue()), uint32(eobHuff.GetLength())), uint(eobHuff.GetLength()))
-	return cw.flushAlign()
 }
 
 func (cw *CompressionWriter) writeCompressedContent(value uint32, nbits uint) error {
@@ -563,46 +793,6 @@ func (cw *CompressionWriter) flushAlign() error {
 	return nil
 }
 
-func tokeniseLZSS(refChannels []chan lzss.Reference) ([]Token, error) {
-	var tokens []Token
-	nextRunesToIgnore := 0
-	for _, channel := range refChannels {
-		ref := <-channel
-		if nextRunesToIgnore > 0 {
-			nextRunesToIgnore--
-		} else if !ref.IsRef || ref.Size < 3 {
-			literalBytes := []byte(string(ref.Value[0]))
-			// fmt.printf("[ flate.tokeniseLZSS ] no match on index %v -- literal: %v\n", i, string(ref.Value[0]))
-			for _, literalByte := range literalBytes {
-				token := Token{
-					Kind:  LiteralToken,
-					Value: literalByte,
-				}
-				tokens = append(tokens, token)
-			}
-		} else {
-			if ref.Size > ref.NegativeOffset {
-				return nil, errors.New("token match overlapping with the reference")
-			}
-			if ref.Size > maxAllowedMatchLength {
-				return nil, fmt.Errorf("token match cannot be longer than %v\n", maxAllowedMatchLength)
-			}
-			if ref.NegativeOffset > maxAllowedBackwardDistance {
-				return nil, fmt.Errorf("token match cannot be farther backward than %v\n", maxAllowedBackwardDistance)
-			}
-			nextRunesToIgnore = ref.Size - 1
-			token := Token{
-				Kind:     MatchToken,
-				Length:   ref.Size,
-				Distance: ref.NegativeOffset,
-			}
-			// fmt.printf("[ flate.tokeniseLZSS ] match on index %v -- Length: %v, Distance: %v\n", i, ref.Size, ref.NegativeOffset)
-			tokens = append(tokens, token)
-		}
-	}
-	return tokens, nil
-}
-
 func findLengthBoundary(items []huffman.CanonicalHuffman, threshold, limit int) ([]int, error) {
 	var length []int
 	var zeros []int
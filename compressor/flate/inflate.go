@@ -1,7 +1,6 @@
 package flate
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -17,113 +16,240 @@ type DecompressionReader struct {
 	core *decompressionCore
 }
 type decompressionCore struct {
-	isInputBufferClosed bool
-	isEobReached        bool
-	cond                *sync.Cond
-	lock                sync.Mutex
-	inputBuffer         io.ReadWriter
-	outputBuffer        io.ReadWriter
-	bitBuffer           *bitBuffer
-	btype               uint32
-	bfinal              uint32
-	readChannel         chan byte
+	isStreamEnded bool
+	lock          sync.Mutex
+	inputReader   *io.PipeReader
+	inputWriter   *io.PipeWriter
+	inflateReader *InflateReader
+}
+
+// InflateReader pulls compressed bits from a BitReader and hands out the
+// decompressed bytes as the blocks decode. Only the last 32 KiB of output is
+// kept around as the window that matches can refer back to.
+type InflateReader struct {
+	bitReader     *huffman.BitReader
+	history       []byte
+	readIdx       int
+	bfinal        uint32
+	btype         uint32
+	isInBlock     bool
+	storedLeft    int
+	litLengthCode *LitLengthCode
+	distanceCode  *DistanceCode
+	blockTypes    []uint32
+	err           error
+	// missingHistory lets matches reach back past the start of the output
+	missingHistory bool
+	missingBytes   int64
 }
 
 func (dr *DecompressionReader) Read(data []byte) (int, error) {
-	dr.core.lock.Lock()
-	defer dr.core.lock.Unlock()
-	for !dr.core.isInputBufferClosed {
-		dr.core.cond.Wait()
+	n, err := dr.core.inflateReader.Read(data)
+	if err == io.EOF {
+		dr.core.lock.Lock()
+		dr.core.isStreamEnded = true
+		dr.core.lock.Unlock()
+		dr.core.inputReader.Close()
+	} else if err != nil {
+		dr.core.inputReader.CloseWithError(err)
 	}
-	return dr.core.outputBuffer.Read(data)
+	return n, err
 }
 
 func (dr *DecompressionReader) Close() error {
-	dr.core.lock.Lock()
-	defer dr.core.lock.Unlock()
-	if buf, ok := dr.core.inputBuffer.(*bytes.Buffer); ok {
-		buf.Reset()
-		dr.core.isInputBufferClosed = false
-		return nil
-	} else {
-		return errors.New("underlying io.ReadWriter is not *bytes.Buffer. Type assertion failed")
-	}
+	return dr.core.inputReader.Close()
 }
 
 func (dw *DecompressionWriter) Write(data []byte) (int, error) {
-	dw.core.lock.Lock()
-	defer dw.core.lock.Unlock()
-	if dw.core.isInputBufferClosed {
-		return 0, errors.New("reading from the compression stream for the previous block has not completed yet!")
+	if n, err := dw.core.inputWriter.Write(data); err != nil {
+		dw.core.lock.Lock()
+		defer dw.core.lock.Unlock()
+		// whatever follows the final block is not part of the stream
+		if dw.core.isStreamEnded {
+			return len(data), nil
+		}
+		return n, err
+	} else {
+		return n, nil
 	}
-	// fmt.Printf("[ flate.DecompressionWriter.Write ] data written to the inputBuffer\n")
-	return dw.core.inputBuffer.Write(data)
 }
 
 func (dw *DecompressionWriter) Close() error {
-	if err := dw.decompress(); err != nil {
-		return err
-	} else {
-		dw.core.lock.Lock()
-		defer dw.core.lock.Unlock()
-
-		dw.core.isInputBufferClosed = true
-		dw.core.cond.Signal()
-		return nil
-	}
+	return dw.core.inputWriter.Close()
 }
 
 func NewDecompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
 	newDecompressionCore := new(decompressionCore)
-	newDecompressionCore.inputBuffer, newDecompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
-	newDecompressionCore.bitBuffer = new(bitBuffer)
-	newDecompressionCore.isInputBufferClosed = false
-	newDecompressionCore.readChannel = make(chan byte)
-	newDecompressionCore.cond = sync.NewCond(&newDecompressionCore.lock)
+	newDecompressionCore.inputReader, newDecompressionCore.inputWriter = io.Pipe()
+	newDecompressionCore.inflateReader = NewInflateReader(huffman.NewBitReader(newDecompressionCore.inputReader))
 	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
 	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
 	// fmt.Printf("[ flate.NewDecompressionReaderAndWriter ] newDecompressionCore: %v\n", newDecompressionCore)
 	return newDecompressionReader, newDecompressionWriter
 }
 
-func (dw *DecompressionWriter) decompress() error {
-	dw.core.lock.Lock()
-	defer dw.core.lock.Unlock()
+func NewInflateReader(bitReader *huffman.BitReader) *InflateReader {
+	return &InflateReader{
+		bitReader: bitReader,
+		history:   make([]byte, 0, 3*maxAllowedBackwardDistance+maxAllowedMatchLength),
+	}
+}
+
+// SetDictionary preloads the window with the preset dictionary the stream
+// was compressed against. It must come before the first Read.
+func (ir *InflateReader) SetDictionary(dictionary []byte) error {
+	if len(ir.history) > 0 || ir.isInBlock || ir.err != nil {
+		return errors.New("preset dictionary must be set before reading from the decompression stream")
+	}
+	dictionary = dictionary[max(0, len(dictionary)-maxAllowedBackwardDistance):]
+	ir.history = append(ir.history, dictionary...)
+	ir.readIdx = len(ir.history)
+	return nil
+}
+
+// Reset points the reader at a new bit stream as if it were new, keeping the
+// window's buffer.
+func (ir *InflateReader) Reset(bitReader *huffman.BitReader) {
+	*ir = InflateReader{
+		bitReader: bitReader,
+		history:   ir.history[:0],
+	}
+}
+
+// AllowMissingHistory is for decoding from the middle of a stream, where
+// matches can refer to output that was never seen. Those bytes come out as
+// zeros instead of failing the block.
+func (ir *InflateReader) AllowMissingHistory() {
+	ir.missingHistory = true
+}
+
+// MissingBytes counts the zero bytes written in place of missing history.
+func (ir *InflateReader) MissingBytes() int64 {
+	return ir.missingBytes
+}
+
+func (ir *InflateReader) Read(data []byte) (int, error) {
+	for ir.readIdx == len(ir.history) {
+		if ir.err != nil {
+			return 0, ir.err
+		}
+		ir.err = ir.fill()
+	}
+	n := copy(data, ir.history[ir.readIdx:])
+	ir.readIdx += n
+	return n, nil
+}
 
-	dataReader := func(nbits uint) (uint32, error) {
-		return readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, nbits)
+// BlockTypes lists the btype of every block started so far, in stream order.
+func (ir *InflateReader) BlockTypes() []uint32 {
+	return ir.blockTypes
+}
+
+// fill decodes roughly another window's worth of output. It is only called
+// once everything decoded so far has been read.
+func (ir *InflateReader) fill() error {
+	if extra := len(ir.history) - maxAllowedBackwardDistance; len(ir.history) >= 2*maxAllowedBackwardDistance {
+		ir.history = append(ir.history[:0], ir.history[extra:]...)
+		ir.readIdx -= extra
+	}
+	if !ir.isInBlock {
+		if ir.bfinal == 1 {
+			return io.EOF
+		}
+		if err := ir.readBlockHeader(); err != nil {
+			return err
+		}
+	}
+	if ir.btype == 0 {
+		return ir.inflateStored()
 	}
+	return ir.inflateHuffman()
+}
+
+func (ir *InflateReader) readBlockHeader() error {
 	// bfinal
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 1); err != nil {
+	if input, err := ir.bitReader.ReadBits(1); err != nil {
 		return err
 	} else {
-		dw.core.bfinal = input
+		ir.bfinal = input
 	}
 
 	// btype
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 2); err != nil {
+	if input, err := ir.bitReader.ReadBits(2); err != nil {
 		return err
 	} else {
-		dw.core.btype = input
+		ir.btype = input
+		ir.blockTypes = append(ir.blockTypes, input)
+	}
+
+	switch ir.btype {
+	case 0:
+		return ir.readStoredHeader()
+	case 1:
+		return ir.readFixedHeader()
+	case 2:
+		return ir.readDynamicHeader()
+	default:
+		return fmt.Errorf("btype %v is not supported for decompression", ir.btype)
+	}
+}
+
+func (ir *InflateReader) readStoredHeader() error {
+	br := ir.bitReader
+	// stored blocks start on the next byte boundary
+	br.AlignToByte()
+	var LEN, NLEN uint32
+	if input, err := br.ReadBits(16); err != nil {
+		return err
+	} else {
+		LEN = input
+	}
+	if input, err := br.ReadBits(16); err != nil {
+		return err
+	} else {
+		NLEN = input
+	}
+	if LEN != ^NLEN&0xFFFF {
+		return fmt.Errorf("stored block length %v does not match its complement %v", LEN, NLEN)
+	}
+	ir.storedLeft = int(LEN)
+	ir.isInBlock = true
+	return nil
+}
+
+func (ir *InflateReader) readFixedHeader() error {
+	newLitLengthCode := new(LitLengthCode)
+	if err := newLitLengthCode.BuildHuffmanTree(toUint32s(fixedLitLengthHuffmanLengths)); err != nil {
+		return err
 	}
+	newDistanceCode := new(DistanceCode)
+	if err := newDistanceCode.BuildHuffmanTree(toUint32s(fixedDistanceHuffmanLengths)); err != nil {
+		return err
+	}
+	ir.litLengthCode, ir.distanceCode = newLitLengthCode, newDistanceCode
+	ir.isInBlock = true
+	return nil
+}
 
+func (ir *InflateReader) readDynamicHeader() error {
+	br := ir.bitReader
 	var HLIT, HDIST, HCLEN uint32
 
 	// HLIT
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 5); err != nil {
+	if input, err := br.ReadBits(5); err != nil {
 		return err
 	} else {
 		HLIT = input
 	}
 	// HDIST
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 5); err != nil {
+	if input, err := br.ReadBits(5); err != nil {
 		return err
 	} else {
 		HDIST = input
 	}
 
 	// HCLEN
-	if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 4); err != nil {
+	if input, err := br.ReadBits(4); err != nil {
 		return err
 	} else {
 		HCLEN = input
@@ -133,29 +259,29 @@ func (dw *DecompressionWriter) decompress() error {
 	HDIST += 1
 	HCLEN += 4
 
-	// fmt.Printf("[ flate.DecompressionWriter.decompress ] HLIT: %v, HDIST: %v, HCLEN: %v\n", HLIT, HDIST, HCLEN)
+	// fmt.Printf("[ flate.InflateReader.readDynamicHeader ] HLIT: %v, HDIST: %v, HCLEN: %v\n", HLIT, HDIST, HCLEN)
 
 	// Code-Length Huffman Length
 	var codeLengthHuffmanLengths []uint32
 	for range HCLEN {
-		if input, err := readCompressedContent(dw.core.bitBuffer, dw.core.inputBuffer, 3); err != nil {
+		if input, err := br.ReadBits(3); err != nil {
 			return err
 		} else {
 			codeLengthHuffmanLengths = append(codeLengthHuffmanLengths, input)
 		}
 	}
-	// fmt.Printf("[ flate.DecompressionWriter.decompress ] codeLengthHuffmanLengths: %v\n", codeLengthHuffmanLengths)
+	// fmt.Printf("[ flate.InflateReader.readDynamicHeader ] codeLengthHuffmanLengths: %v\n", codeLengthHuffmanLengths)
 	newCodeLengthCode := new(CodeLengthCode)
-	newCodeLengthCode.BuildHuffmanTree(codeLengthHuffmanLengths)
+	if err := newCodeLengthCode.BuildHuffmanTree(codeLengthHuffmanLengths); err != nil {
+		return err
+	}
 
 	// Expanded Huffman Lengths
 	newLitLengthCode := new(LitLengthCode)
 	newDistanceCode := new(DistanceCode)
-	if litLenHuffmanLengths, distHuffmanLengths, err := newCodeLengthCode.ReadCondensedHuffman(dataReader, HLIT, HDIST); err != nil {
+	if litLenHuffmanLengths, distHuffmanLengths, err := newCodeLengthCode.ReadCondensedHuffman(br, HLIT, HDIST); err != nil {
 		return err
 	} else {
-		// fmt.printf("[ flate.DecompressionWriter.decompress ] len(litLenHuffmanLengths): %v, len(distHuffmanLengths): %v\n", len(litLenHuffmanLengths), len(distHuffmanLengths))
-		// fmt.printf("[ flate.DecompressionWriter.decompress ] litLenHuffmanLengths: %v, distHuffmanLengths: %v\n", litLenHuffmanLengths, distHuffmanLengths)
 		if err := newLitLengthCode.BuildHuffmanTree(litLenHuffmanLengths); err != nil {
 			return err
 		}
@@ -163,88 +289,90 @@ func (dw *DecompressionWriter) decompress() error {
 			return err
 		}
 	}
-	// Now I have built all the huffman tree
-	// Read Token, the huffman code is decoded.
-	if tokens, err := ReadTokens(dataReader, newLitLengthCode, newDistanceCode); err != nil {
+	ir.litLengthCode, ir.distanceCode = newLitLengthCode, newDistanceCode
+	ir.isInBlock = true
+	return nil
+}
+
+func (ir *InflateReader) inflateStored() error {
+	n := min(ir.storedLeft, maxAllowedBackwardDistance)
+	start := len(ir.history)
+	ir.history = ir.history[:start+n]
+	if err := ir.bitReader.ReadAlignedBytes(ir.history[start:]); err != nil {
+		ir.history = ir.history[:start]
 		return err
-	} else {
-		// tokens should be converted into text as the decompressed data
-		data := DecodeTokens(tokens)
-		// fmt.printf("[ flate.DecompressionWriter.decompress ] decompressed data: %v\n", string(data))
-		if _, err := dw.core.outputBuffer.Write(data); err != nil {
-			return err
-		}
 	}
+	ir.storedLeft -= n
+	ir.isInBlock = ir.storedLeft > 0
 	return nil
 }
 
-func DecodeTokens(tokens []Token) []byte {
-	var output []byte
-	findMatch := func(length, negOffset int) {
-		outputSoFarRune := []rune(string(output))
-		currentIdx := len(outputSoFarRune)
-		startIdx := currentIdx - negOffset
-		endIdx := startIdx + length
-		match := []byte(string(outputSoFarRune[startIdx:endIdx]))
-		// fmt.printf("[ flate.DecodeTokens.findMatch ] outputSoFar: %v\nmatch: %v\n", string(output), string(match))
-		output = append(output, match...)
-	}
-	for _, token := range tokens {
-		switch token.Kind {
-		case LiteralToken:
-			output = append(output, token.Value)
-		case MatchToken:
-			findMatch(token.Length, token.Distance)
+func (ir *InflateReader) inflateHuffman() error {
+	for len(ir.history)-ir.readIdx < maxAllowedBackwardDistance {
+		if token, err := ReadToken(ir.bitReader, ir.litLengthCode, ir.distanceCode); err != nil {
+			return err
+		} else {
+			switch token.Kind {
+			case LiteralToken:
+				ir.history = append(ir.history, token.Value)
+			case MatchToken:
+				startIdx := len(ir.history) - token.Distance
+				if startIdx < 0 && ir.missingHistory {
+					missing := min(-startIdx, token.Length)
+					for range missing {
+						ir.history = append(ir.history, 0)
+					}
+					ir.missingBytes += int64(missing)
+					token.Length -= missing
+					startIdx = len(ir.history) - token.Distance
+				} else if startIdx < 0 {
+					return fmt.Errorf("match distance %v is farther back than the decoded history", token.Distance)
+				}
+				// copy byte by byte since a match may overlap the bytes it produces
+				for i := range token.Length {
+					ir.history = append(ir.history, ir.history[startIdx+i])
+				}
+			case EndOfBlockToken:
+				ir.isInBlock = false
+				return nil
+			}
 		}
 	}
-	return output
+	return nil
 }
 
-func readCompressedContent(bb *bitBuffer, inputBuffer io.ReadWriter, nbits uint) (uint32, error) {
-	if nbits > 32 {
-		return 0, errors.New("cannot read more than 32 bits at once.")
+func toUint32s(items []int) []uint32 {
+	output := make([]uint32, len(items))
+	for i, item := range items {
+		output[i] = uint32(item)
 	}
-	for bb.bitsCount < nbits {
-		newData := make([]byte, 1)
-		if _, err := inputBuffer.Read(newData); err != nil {
-			return 0, fmt.Errorf("not enough bits to read from the compressed data: %v\n", err)
-		}
-		bb.bitsHolder |= uint32(newData[0]) << uint32(bb.bitsCount)
-		bb.bitsCount += 8
-	}
-	output := bb.bitsHolder & ((1 << nbits) - 1)
-	bb.bitsHolder >>= uint32(nbits)
-	bb.bitsCount -= nbits
-	return output, nil
+	return output
 }
 
 func (clc *CodeLengthCode) BuildHuffmanTree(huffmanLengths []uint32) error {
 	huffmanLengths = clc.reshuffle(huffmanLengths)
-	if canonicalRoot, err := huffman.BuildCanonicalHuffmanDecoder(huffmanLengths); err != nil {
+	if decoder, err := huffman.BuildTableDecoder(huffmanLengths); err != nil {
 		return err
 	} else {
-		clc.CanonicalRoot = canonicalRoot
-		// traceTree(clc.CanonicalRoot, 0)
+		clc.Decoder = decoder
 	}
 	return nil
 }
 
 func (llc *LitLengthCode) BuildHuffmanTree(huffmanLengths []uint32) error {
-	if canonicalRoot, err := huffman.BuildCanonicalHuffmanDecoder(huffmanLengths); err != nil {
+	if decoder, err := huffman.BuildTableDecoder(huffmanLengths); err != nil {
 		return err
 	} else {
-		llc.CanonicalRoot = canonicalRoot
-		// traceTree(llc.CanonicalRoot, 0)
+		llc.Decoder = decoder
 	}
 	return nil
 }
 
 func (dc *DistanceCode) BuildHuffmanTree(huffmanLengths []uint32) error {
-	if canonicalRoot, err := huffman.BuildCanonicalHuffmanDecoder(huffmanLengths); err != nil {
+	if decoder, err := huffman.BuildTableDecoder(huffmanLengths); err != nil {
 		return err
 	} else {
-		dc.CanonicalRoot = canonicalRoot
-		// traceTree(dc.CanonicalRoot, 0)
+		dc.Decoder = decoder
 	}
 	return nil
 }
@@ -263,7 +391,7 @@ func (clc *CodeLengthCode) reshuffle(huffmanLengths []uint32) []uint32 {
 	return lengths
 }
 
-func (clc *CodeLengthCode) ReadCondensedHuffman(dataReader func(uint) (uint32, error), HLIT, HDIST uint32) ([]uint32, []uint32, error) {
+func (clc *CodeLengthCode) ReadCondensedHuffman(br *huffman.BitReader, HLIT, HDIST uint32) ([]uint32, []uint32, error) {
 	total := HLIT + HDIST
 	var concatenatedHuffmanLengths []uint32
 	// fmt.printf("[ flate.CodeLengthCode.ReadCondensedHuffman.expandRule ] rule:\n")
@@ -271,7 +399,7 @@ func (clc *CodeLengthCode) ReadCondensedHuffman(dataReader func(uint) (uint32, e
 		extraBits := rleAlphabets.Alphabets[rule].ExtraBits
 		var offset int
 		if extraBits > 0 {
-			if o, err := dataReader(uint(extraBits)); err != nil {
+			if o, err := br.ReadBits(uint(extraBits)); err != nil {
 				return nil, err
 			} else {
 				offset = int(o)
@@ -304,10 +432,12 @@ func (clc *CodeLengthCode) ReadCondensedHuffman(dataReader func(uint) (uint32, e
 	}
 	cnt := 0
 	for len(concatenatedHuffmanLengths) < int(total) {
-		if rule, err := TraverseHuffmanTree(dataReader, clc.CanonicalRoot); err != nil {
+		if rule, err := clc.Decoder.Decode(br); err != nil {
 			return nil, nil, err
 		} else if lengths, err := expandRule(int(rule)); err != nil {
 			return nil, nil, err
+		} else if len(concatenatedHuffmanLengths)+len(lengths) > int(total) {
+			return nil, nil, errors.New("invalid code lengths set")
 		} else {
 			cnt++
 			concatenatedHuffmanLengths = append(concatenatedHuffmanLengths, lengths...)
@@ -319,98 +449,75 @@ func (clc *CodeLengthCode) ReadCondensedHuffman(dataReader func(uint) (uint32, e
 	return concatenatedHuffmanLengths[:HLIT], concatenatedHuffmanLengths[HLIT : HLIT+HDIST], nil
 }
 
-func TraverseHuffmanTree(dataReader func(uint) (uint32, error), node *huffman.CanonicalHuffmanNode) (uint32, error) {
-	if node.IsLeaf {
-		return uint32(node.Item.GetValue()), nil
-	}
-	if input, err := dataReader(1); err != nil {
-		return 0, err
-	} else if input == 0 {
-		if node.Left == nil {
-			return 0, errors.New("tree traversal failed due to absence of appropriate subtree")
+func decodeLitLenRule(br *huffman.BitReader, rule int) (TokenKind, int, int, error) {
+	extraBits := lenAlphabets.Alphabets[rule].ExtraBits
+	var offset int
+	if extraBits > 0 {
+		if o, err := br.ReadBits(uint(extraBits)); err != nil {
+			return 0, 0, 0, err
 		} else {
-			return TraverseHuffmanTree(dataReader, node.Left)
+			offset = int(o)
 		}
+	}
+	if rule < 256 {
+		return LiteralToken, rule, 0, nil
+	} else if rule == 256 {
+		return EndOfBlockToken, rule, 0, nil
+	} else if rule < 286 {
+		length := lenAlphabets.Alphabets[rule].Base + offset
+		return MatchToken, length, offset, nil
 	} else {
-		if node.Right == nil {
-			return 0, errors.New("tree traversal failed due to absence of appropriate subtree")
-		} else {
-			return TraverseHuffmanTree(dataReader, node.Right)
-		}
+		return 0, 0, 0, errors.New("no match found for the rule")
 	}
 }
 
-func ReadTokens(dataReader func(uint) (uint32, error), newlitLenthCode *LitLengthCode, newDistanceCode *DistanceCode) ([]Token, error) {
-	var tokens []Token
-	decodeLitLenRule := func(rule int) (TokenKind, int, int, error) {
-		extraBits := lenAlphabets.Alphabets[rule].ExtraBits
-		var offset int
-		if extraBits > 0 {
-			if o, err := dataReader(uint(extraBits)); err != nil {
-				return 0, 0, 0, err
-			} else {
-				offset = int(o)
-			}
-		}
-		if rule < 256 {
-			return LiteralToken, rule, 0, nil
-		} else if rule == 256 {
-			return EndOfBlockToken, rule, 0, nil
-		} else if rule < 286 {
-			length := lenAlphabets.Alphabets[rule].Base + offset
-			return MatchToken, length, offset, nil
+func decodeDistRule(br *huffman.BitReader, rule int) (int, int, error) {
+	if _, ok := distAlphabets.Alphabets[rule]; !ok {
+		return 0, 0, fmt.Errorf("invalid distance code %v", rule)
+	}
+	extraBits := distAlphabets.Alphabets[rule].ExtraBits
+	var offset int
+	if extraBits > 0 {
+		if o, err := br.ReadBits(uint(extraBits)); err != nil {
+			return 0, 0, err
 		} else {
-			return 0, 0, 0, errors.New("no match found for the rule")
+			offset = int(o)
 		}
 	}
-	decodeDistRule := func(rule int) (int, int, error) {
-		extraBits := distAlphabets.Alphabets[rule].ExtraBits
-		var offset int
-		if extraBits > 0 {
-			if o, err := dataReader(uint(extraBits)); err != nil {
-				return 0, 0, err
-			} else {
-				offset = int(o)
-			}
+	distance := distAlphabets.Alphabets[rule].Base + offset
+	return distance, offset, nil
+}
+
+// ReadToken decodes the next literal, match or end-of-block symbol.
+func ReadToken(br *huffman.BitReader, newlitLenthCode *LitLengthCode, newDistanceCode *DistanceCode) (Token, error) {
+	var token Token
+	if rule, err := newlitLenthCode.Decoder.Decode(br); err != nil {
+		return token, err
+	} else if tokenKind, value, lengthOffset, err := decodeLitLenRule(br, rule); err != nil {
+		return token, err
+	} else if tokenKind == MatchToken {
+		token = Token{
+			Kind:         tokenKind,
+			Length:       value,
+			LengthCode:   rule,
+			LengthOffset: lengthOffset,
 		}
-		distance := distAlphabets.Alphabets[rule].Base + offset
-		return distance, offset, nil
-	}
-	for true {
-		if rule, err := TraverseHuffmanTree(dataReader, newlitLenthCode.CanonicalRoot); err != nil {
-			return nil, err
+		if rule, err := newDistanceCode.Decoder.Decode(br); err != nil {
+			return token, err
+		} else if distance, distanceOffset, err := decodeDistRule(br, rule); err != nil {
+			return token, err
 		} else {
-			var token Token
-			if tokenKind, value, lengthOffset, err := decodeLitLenRule(int(rule)); err != nil {
-				return nil, err
-			} else if tokenKind == MatchToken {
-				token = Token{
-					Kind:         tokenKind,
-					Length:       value,
-					LengthCode:   int(rule),
-					LengthOffset: lengthOffset,
-				}
-				if rule, err := TraverseHuffmanTree(dataReader, newDistanceCode.CanonicalRoot); err != nil {
-					return nil, err
-				} else {
-					if distance, distanceOffset, err := decodeDistRule(int(rule)); err != nil {
-						return nil, err
-					} else {
-						token.Distance = distance
-						token.DistanceCode = int(rule)
-						token.DistanceOffset = distanceOffset
-					}
-				}
-			} else if tokenKind == LiteralToken {
-				token = Token{
-					Kind:  tokenKind,
-					Value: byte(value),
-				}
-			} else if tokenKind == EndOfBlockToken {
-				return tokens, nil
-			}
-			tokens = append(tokens, token)
+			token.Distance = distance
+			token.DistanceCode = rule
+			token.DistanceOffset = distanceOffset
+		}
+	} else if tokenKind == LiteralToken {
+		token = Token{
+			Kind:  tokenKind,
+			Value: byte(value),
 		}
+	} else {
+		token.Kind = tokenKind
 	}
-	return nil, errors.New("this line should never be reached")
+	return token, nil
 }
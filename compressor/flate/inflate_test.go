@@ -0,0 +1,36 @@
+package flate
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// A sync flush has to be enough for the reader to see everything written so
+// far, with the compressed stream still open.
+func TestReadAfterFlush(t *testing.T) {
+	message := []byte("a sync flush hands over every byte written before it\n")
+	compressionReader, compressionWriter := NewCompressionReaderAndWriter(2, 6, defaultBlockSize)
+	decompressionReader, decompressionWriter := NewDecompressionReaderAndWriter()
+	go io.Copy(decompressionWriter, compressionReader)
+	go func() {
+		compressionWriter.Write(message)
+		compressionWriter.(*CompressionWriter).Flush()
+	}()
+	received := make(chan []byte)
+	go func() {
+		output := make([]byte, len(message))
+		n, _ := io.ReadFull(decompressionReader, output)
+		received <- output[:n]
+	}()
+	select {
+	case output := <-received:
+		if !bytes.Equal(output, message) {
+			t.Fatalf("read %q after the flush, want %q", output, message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader is still waiting for input after the flush")
+	}
+	compressionWriter.Close()
+}
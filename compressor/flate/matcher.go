@@ -0,0 +1,178 @@
+package flate
+
+const (
+	minMatchLength = 3
+	hashBits       = 15
+	hashSize       = 1 << hashBits
+	hashShift      = 32 - hashBits
+	tooFarDistance = 4096
+)
+
+type compressionLevel struct {
+	good, lazy, nice, chain int
+	isLazy                  bool
+}
+
+// same tuning as zlib: levels 1-3 match greedily and use lazy as the
+// longest match still inserted into the hash chains, levels 4-9 defer
+// each match by one byte in case a longer one starts there
+var compressionLevels = [...]compressionLevel{
+	0: {},
+	1: {good: 4, lazy: 4, nice: 8, chain: 4},
+	2: {good: 4, lazy: 5, nice: 16, chain: 8},
+	3: {good: 4, lazy: 6, nice: 32, chain: 32},
+	4: {good: 4, lazy: 4, nice: 16, chain: 16, isLazy: true},
+	5: {good: 8, lazy: 16, nice: 32, chain: 32, isLazy: true},
+	6: {good: 8, lazy: 16, nice: 128, chain: 128, isLazy: true},
+	7: {good: 8, lazy: 32, nice: 128, chain: 256, isLazy: true},
+	8: {good: 32, lazy: 128, nice: 258, chain: 1024, isLazy: true},
+	9: {good: 32, lazy: 258, nice: 258, chain: 4096, isLazy: true},
+}
+
+type matcher struct {
+	head  []int32
+	prev  []int32
+	level compressionLevel
+}
+
+func newMatcher(size int, level compressionLevel) *matcher {
+	m := &matcher{
+		head:  make([]int32, hashSize),
+		prev:  make([]int32, size),
+		level: level,
+	}
+	m.level.nice = min(m.level.nice, maxAllowedMatchLength)
+	for i := range m.head {
+		m.head[i] = -1
+	}
+	return m
+}
+
+func hash3(data []byte, pos int) uint32 {
+	value := uint32(data[pos]) | uint32(data[pos+1])<<8 | uint32(data[pos+2])<<16
+	return (value * 0x9E3779B1) >> hashShift
+}
+
+func (m *matcher) insert(data []byte, pos int) {
+	if pos+minMatchLength > len(data) {
+		return
+	}
+	h := hash3(data, pos)
+	m.prev[pos] = m.head[h]
+	m.head[h] = int32(pos)
+}
+
+func (m *matcher) longestMatch(data []byte, pos int, chain int) (length int, distance int) {
+	if pos+minMatchLength > len(data) {
+		return 0, 0
+	}
+	limit := min(maxAllowedMatchLength, len(data)-pos)
+	candidate := int(m.head[hash3(data, pos)])
+	for ; candidate >= 0 && chain > 0; chain-- {
+		if pos-candidate > maxAllowedBackwardDistance {
+			break
+		}
+		if data[candidate+length] == data[pos+length] {
+			n := 0
+			for n < limit && data[candidate+n] == data[pos+n] {
+				n++
+			}
+			if n > length {
+				length, distance = n, pos-candidate
+				if n >= m.level.nice || n == limit {
+					break
+				}
+			}
+		}
+		candidate = int(m.prev[candidate])
+	}
+	if length < minMatchLength || (length == minMatchLength && distance > tooFarDistance) {
+		return 0, 0
+	}
+	return length, distance
+}
+
+func (m *matcher) tokenise(data []byte, start int) []Token {
+	for pos := 0; pos < start; pos++ {
+		m.insert(data, pos)
+	}
+	if m.level.isLazy {
+		return m.tokeniseLazy(data, start)
+	}
+	return m.tokeniseGreedy(data, start)
+}
+
+func (m *matcher) tokeniseGreedy(data []byte, start int) []Token {
+	var tokens []Token
+	for pos := start; pos < len(data); {
+		length, distance := m.longestMatch(data, pos, m.level.chain)
+		m.insert(data, pos)
+		if length == 0 {
+			tokens = append(tokens, Token{
+				Kind:  LiteralToken,
+				Value: data[pos],
+			})
+			pos++
+			continue
+		}
+		tokens = append(tokens, Token{
+			Kind:     MatchToken,
+			Length:   length,
+			Distance: distance,
+		})
+		end := pos + length
+		if length <= m.level.lazy {
+			for pos++; pos < end; pos++ {
+				m.insert(data, pos)
+			}
+		}
+		pos = end
+	}
+	return tokens
+}
+
+func (m *matcher) tokeniseLazy(data []byte, start int) []Token {
+	var tokens []Token
+	prevLength, prevDistance := 0, 0
+	isLiteralPending := false
+	for pos := start; pos < len(data); {
+		length, distance := 0, 0
+		if prevLength < m.level.lazy {
+			chain := m.level.chain
+			if prevLength >= m.level.good {
+				chain >>= 2
+			}
+			length, distance = m.longestMatch(data, pos, chain)
+		}
+		m.insert(data, pos)
+		if prevLength >= minMatchLength && length <= prevLength {
+			// the match found at the previous byte wins, it starts at pos-1
+			tokens = append(tokens, Token{
+				Kind:     MatchToken,
+				Length:   prevLength,
+				Distance: prevDistance,
+			})
+			end := pos - 1 + prevLength
+			for pos++; pos < end; pos++ {
+				m.insert(data, pos)
+			}
+			prevLength, isLiteralPending = 0, false
+			continue
+		}
+		if isLiteralPending {
+			tokens = append(tokens, Token{
+				Kind:  LiteralToken,
+				Value: data[pos-1],
+			})
+		}
+		prevLength, prevDistance, isLiteralPending = length, distance, true
+		pos++
+	}
+	if isLiteralPending {
+		tokens = append(tokens, Token{
+			Kind:  LiteralToken,
+			Value: data[len(data)-1],
+		})
+	}
+	return tokens
+}
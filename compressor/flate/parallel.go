@@ -0,0 +1,310 @@
+package flate
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// defaultChunkSize is the input each worker takes at a time, as in pigz
+var defaultChunkSize int = 128 * 1024
+
+type chunkResult struct {
+	output []byte
+	crc    uint32
+	size   int
+	err    error
+}
+
+type parallelCompressionCore struct {
+	lock    sync.Mutex
+	Writer  *io.PipeWriter
+	Reader  *io.PipeReader
+	pending []byte
+	// cuts are the rsync boundaries inside pending, too close together to
+	// be worth a chunk each
+	cuts      []int
+	window    []byte
+	btype     uint32
+	level     int
+	blockSize int
+	chunkSize int
+	isClosed  bool
+	workers   chan struct{}
+	results   chan chan chunkResult
+	done      chan error
+	crc       uint32
+	rsync     *rsyncRoller
+}
+
+type ParallelCompressionWriter struct {
+	core *parallelCompressionCore
+}
+
+type ParallelCompressionReader struct {
+	core *parallelCompressionCore
+}
+
+// NewParallelCompressionReaderAndWriter splits the input into chunks and
+// deflates up to threads of them at once. Each chunk is primed with the 32 KiB
+// before it and all but the last end on a sync flush, so the chunks join up
+// into one ordinary deflate stream.
+func NewParallelCompressionReaderAndWriter(btype uint32, level int, blockSize int, threads int) (io.ReadCloser, io.WriteCloser) {
+	newCompressionCore := new(parallelCompressionCore)
+	newCompressionCore.Reader, newCompressionCore.Writer = io.Pipe()
+	newCompressionCore.btype = btype
+	newCompressionCore.level = level
+	if blockSize <= 0 {
+		blockSize = defaultBlockSize
+	}
+	newCompressionCore.blockSize = blockSize
+	newCompressionCore.chunkSize = max(defaultChunkSize, blockSize)
+	threads = max(threads, 1)
+	newCompressionCore.workers = make(chan struct{}, threads)
+	// chunks waiting to be written out, in input order
+	newCompressionCore.results = make(chan chan chunkResult, threads)
+	newCompressionCore.done = make(chan error, 1)
+	newCompressionReader, newCompressionWriter := new(ParallelCompressionReader), new(ParallelCompressionWriter)
+	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
+	go newCompressionCore.pump()
+	return newCompressionReader, newCompressionWriter
+}
+
+// pump writes the chunks out in order as they finish and folds their CRCs
+// into one.
+func (core *parallelCompressionCore) pump() {
+	var err error
+	for result := range core.results {
+		chunk := <-result
+		if err != nil {
+			continue
+		}
+		if err = chunk.err; err == nil {
+			_, err = core.Writer.Write(chunk.output)
+		}
+		core.crc = crc32Combine(core.crc, chunk.crc, int64(chunk.size))
+	}
+	core.Writer.CloseWithError(err)
+	core.done <- err
+}
+
+func (cw *ParallelCompressionWriter) Write(data []byte) (int, error) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return 0, errors.New("cannot write to a closed compression stream")
+	}
+	written := 0
+	for len(data) > 0 {
+		n := min(len(data), cw.core.chunkSize+1-len(cw.core.pending))
+		boundary := false
+		if cw.core.rsync != nil {
+			if end := cw.core.rsync.roll(data[:n]); end >= 0 {
+				n, boundary = end, true
+			}
+		}
+		cw.core.pending = append(cw.core.pending, data[:n]...)
+		data, written = data[n:], written+n
+		if boundary && len(cw.core.pending) >= cw.core.chunkSize/4 {
+			cw.core.dispatch(cw.core.pending, cw.core.cuts, false)
+			cw.core.pending, cw.core.cuts = nil, nil
+		} else if boundary {
+			cw.core.cuts = append(cw.core.cuts, len(cw.core.pending))
+		} else if len(cw.core.pending) > cw.core.chunkSize {
+			// the last chunk is only known at Close, so a full chunk waits for one more byte
+			cw.core.dispatch(cw.core.pending[:cw.core.chunkSize], cw.core.cuts, false)
+			cw.core.pending, cw.core.cuts = append([]byte(nil), cw.core.pending[cw.core.chunkSize:]...), nil
+		}
+	}
+	return written, nil
+}
+
+// Flush hands whatever is pending to a worker as a sync-flushed chunk.
+func (cw *ParallelCompressionWriter) Flush() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return errors.New("cannot flush a closed compression stream")
+	}
+	cw.core.dispatch(cw.core.pending, cw.core.cuts, false)
+	cw.core.pending, cw.core.cuts = nil, nil
+	return nil
+}
+
+// SetRsyncable also cuts chunks where a rolling sum over the input says so.
+// It must come before any Write.
+func (cw *ParallelCompressionWriter) SetRsyncable(rsyncable bool) error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed || len(cw.core.pending) > 0 || len(cw.core.window) > 0 {
+		return errors.New("rsyncable must be set before writing to the compression stream")
+	}
+	cw.core.rsync = nil
+	if rsyncable {
+		cw.core.rsync = new(rsyncRoller)
+	}
+	return nil
+}
+
+func (cw *ParallelCompressionWriter) Close() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return errors.New("compression stream is already closed")
+	}
+	cw.core.isClosed = true
+	cw.core.dispatch(cw.core.pending, cw.core.cuts, true)
+	cw.core.pending, cw.core.cuts, cw.core.window = nil, nil, nil
+	close(cw.core.results)
+	return <-cw.core.done
+}
+
+// Crc32 is the CRC-32 of everything written, put together from the CRCs of
+// the chunks. It is complete once Close returns.
+func (cw *ParallelCompressionWriter) Crc32() uint32 {
+	return cw.core.crc
+}
+
+func (cr *ParallelCompressionReader) Read(data []byte) (int, error) {
+	return cr.core.Reader.Read(data)
+}
+
+func (cr *ParallelCompressionReader) Close() error {
+	return cr.core.Reader.Close()
+}
+
+// dispatch queues chunk behind the ones before it, blocking while every
+// worker is busy.
+func (core *parallelCompressionCore) dispatch(chunk []byte, cuts []int, final bool) {
+	dictionary := core.window
+	result := make(chan chunkResult, 1)
+	core.results <- result
+	core.workers <- struct{}{}
+	go func() {
+		defer func() { <-core.workers }()
+		output, err := compressChunk(chunk, dictionary, cuts, core.btype, core.level, core.blockSize, final)
+		result <- chunkResult{
+			output: output,
+			crc:    crc32.ChecksumIEEE(chunk),
+			size:   len(chunk),
+			err:    err,
+		}
+	}()
+	// chunks are never written to once dispatched, so the window can share them
+	if len(chunk) >= maxAllowedBackwardDistance {
+		core.window = chunk[len(chunk)-maxAllowedBackwardDistance:]
+	} else {
+		window := append(append([]byte(nil), dictionary...), chunk...)
+		core.window = window[max(0, len(window)-maxAllowedBackwardDistance):]
+	}
+}
+
+// compressChunk deflates a chunk on a writer of its own, the same way
+// CompressionWriter cuts the input into blocks. Every cut inside the chunk
+// ends a block with a sync flush, as an rsync boundary does there.
+func compressChunk(chunk, dictionary []byte, cuts []int, btype uint32, level int, blockSize int, final bool) ([]byte, error) {
+	_, writer := NewCompressionReaderAndWriter(btype, level, blockSize)
+	cw := writer.(*CompressionWriter)
+	cw.slideWindow(dictionary)
+	start := 0
+	for _, cut := range cuts {
+		if cut <= start || cut >= len(chunk) {
+			continue
+		}
+		segment := chunk[start:cut]
+		for len(segment) > blockSize {
+			if err := cw.writeBlock(segment[:blockSize], 0); err != nil {
+				return nil, err
+			}
+			segment = segment[blockSize:]
+		}
+		if err := cw.writeBlock(segment, 0); err != nil {
+			return nil, err
+		}
+		if err := cw.writeStoredBlock(nil, 0); err != nil {
+			return nil, err
+		}
+		start = cut
+	}
+	chunk = chunk[start:]
+	for len(chunk) > blockSize {
+		if err := cw.writeBlock(chunk[:blockSize], 0); err != nil {
+			return nil, err
+		}
+		chunk = chunk[blockSize:]
+	}
+	if final {
+		if err := cw.writeBlock(chunk, 1); err != nil {
+			return nil, err
+		}
+		if err := cw.flushAlign(); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(chunk) > 0 {
+			if err := cw.writeBlock(chunk, 0); err != nil {
+				return nil, err
+			}
+		}
+		// sync flush, leaves the stream byte aligned for the next chunk
+		if err := cw.writeStoredBlock(nil, 0); err != nil {
+			return nil, err
+		}
+	}
+	return cw.core.outputBuffer.Bytes(), nil
+}
+
+// crc32Combine returns the CRC-32 of a+b from the CRCs of a and b and the
+// length of b, by running crcA through lengthB zero bytes in GF(2), as
+// zlib's crc32_combine does.
+func crc32Combine(crcA, crcB uint32, lengthB int64) uint32 {
+	if lengthB <= 0 {
+		return crcA
+	}
+	var even, odd [32]uint32
+	// operator for one zero bit
+	odd[0] = 0xedb88320
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+	gf2MatrixSquare(even[:], odd[:]) // two zero bits
+	gf2MatrixSquare(odd[:], even[:]) // four zero bits
+	for {
+		gf2MatrixSquare(even[:], odd[:])
+		if lengthB&1 != 0 {
+			crcA = gf2MatrixTimes(even[:], crcA)
+		}
+		lengthB >>= 1
+		if lengthB == 0 {
+			break
+		}
+		gf2MatrixSquare(odd[:], even[:])
+		if lengthB&1 != 0 {
+			crcA = gf2MatrixTimes(odd[:], crcA)
+		}
+		lengthB >>= 1
+		if lengthB == 0 {
+			break
+		}
+	}
+	return crcA ^ crcB
+}
+
+func gf2MatrixTimes(matrix []uint32, vector uint32) uint32 {
+	var sum uint32
+	for i := 0; vector != 0; i, vector = i+1, vector>>1 {
+		if vector&1 != 0 {
+			sum ^= matrix[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, matrix []uint32) {
+	for n := range matrix {
+		square[n] = gf2MatrixTimes(matrix, matrix[n])
+	}
+}
@@ -0,0 +1,38 @@
+package flate
+
+// rsyncWindow is the span of input the rolling sum covers, as in gzip's
+// rsyncable patch. A boundary falls wherever the sum is a multiple of it.
+const rsyncWindow = 4096
+
+// rsyncRoller finds content-defined boundaries, so the same input cuts in the
+// same places wherever it sits in the stream.
+type rsyncRoller struct {
+	sum     uint32
+	history [rsyncWindow]byte
+	seen    int64
+	// sinceBoundary keeps boundaries a window apart. A run of one byte value
+	// holds the sum on a multiple of the window, which would otherwise make
+	// every byte after it a boundary.
+	sinceBoundary int64
+}
+
+// roll feeds data through the rolling sum and returns the length up to and
+// including the first boundary, or -1 when there is none. Bytes after the
+// boundary are not rolled.
+func (rr *rsyncRoller) roll(data []byte) int {
+	for i, b := range data {
+		idx := rr.seen % rsyncWindow
+		if rr.seen >= rsyncWindow {
+			rr.sum -= uint32(rr.history[idx])
+		}
+		rr.sum += uint32(b)
+		rr.history[idx] = b
+		rr.seen++
+		rr.sinceBoundary++
+		if rr.sinceBoundary >= rsyncWindow && rr.sum%rsyncWindow == 0 {
+			rr.sinceBoundary = 0
+			return i + 1
+		}
+	}
+	return -1
+}
@@ -2,10 +2,10 @@ package gzip
 
 import (
 	"encoding/binary"
+	"errors"
 	"hash"
 	"hash/crc32"
 	"io"
-	"os"
 	"sync"
 )
 
@@ -17,6 +17,15 @@ type CompressionCore struct {
 	FlateReader io.ReadCloser
 	Crc         hash.Hash32
 	Size        uint32
+	Header      []byte
+	err         error
+	done        chan error
+}
+
+// checksummer is a flate writer that works out the CRC-32 of its input itself,
+// as the parallel writer does from the CRCs of its chunks
+type checksummer interface {
+	Crc32() uint32
 }
 
 type CompressionReader struct {
@@ -27,90 +36,98 @@ type CompressionWriter struct {
 	core *CompressionCore
 }
 
-func NewCompressionReaderAndWriter(flateReader io.ReadCloser, flateWriter io.WriteCloser) (io.ReadCloser, io.WriteCloser) {
+func NewCompressionReaderAndWriter(flateReader io.ReadCloser, flateWriter io.WriteCloser, level int, header Header) (io.ReadCloser, io.WriteCloser) {
 	newCompressionCore := new(CompressionCore)
-	// fmt.Printf("[ gzip.NewCompressionReaderAndWriter ] 1\n")
 	newCompressionCore.Reader, newCompressionCore.Writer = io.Pipe()
-	// fmt.Printf("[ gzip.NewCompressionReaderAndWriter ] 2\n")
 	newCompressionCore.FlateReader, newCompressionCore.FlateWriter = flateReader, flateWriter
 	newCompressionCore.Crc = crc32.NewIEEE()
+	newCompressionCore.done = make(chan error, 1)
 	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
 	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
-	header := [10]byte{
-		0x1f, 0x8b, // ID1, ID2
-		0x08,       // CM = deflate
-		0x00,       // FLG
-		0, 0, 0, 0, // MTIME
-		0x00, // XFL
-		0xff, // OS = unknown
+	var xfl byte
+	switch level {
+	case 9:
+		xfl = 0x02 // maximum compression
+	case 1:
+		xfl = 0x04 // fastest algorithm
 	}
-	// fmt.Printf("[ gzip.NewCompressionReaderAndWriter ] 3\n")
-	go newCompressionCore.Writer.Write(header[:])
-	// fmt.Printf("[ gzip.NewCompressionReaderAndWriter ] 4\n")
+	// a header that cannot be written fails the first Write or Close
+	newCompressionCore.Header, newCompressionCore.err = header.bytes(xfl)
+	go newCompressionCore.pump()
 	return newCompressionReader, newCompressionWriter
 }
 
+// pump forwards the header and then the deflate stream as the flate writer
+// produces it, so compressed blocks reach the reader before Close.
+func (core *CompressionCore) pump() {
+	_, err := core.Writer.Write(core.Header)
+	if err == nil {
+		_, err = io.Copy(core.Writer, core.FlateReader)
+	}
+	if err != nil {
+		core.FlateReader.Close()
+	}
+	core.done <- err
+}
+
 func (cw *CompressionWriter) Write(p []byte) (int, error) {
-	// fmt.Printf("[ gzip.CompressionWriter.Write ] 1\n")
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
-	// fmt.Printf("[ gzip.CompressionWriter.Write ] 2\n")
-	if f, err := os.OpenFile("com.o", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644); err != nil {
-		panic(err)
-	} else {
-		f.Write(p)
+	if cw.core.err != nil {
+		return 0, cw.core.err
+	}
+	if _, ok := cw.core.FlateWriter.(checksummer); !ok {
+		cw.core.Crc.Write(p)
 	}
-	cw.core.Crc.Write(p)
 	cw.core.Size += uint32(len(p))
 	return cw.core.FlateWriter.Write(p)
 }
 
+func (cw *CompressionWriter) Flush() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if flusher, ok := cw.core.FlateWriter.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return errors.New("underlying flate writer does not support flushing")
+}
+
 func (cw *CompressionWriter) Close() error {
-	// cw.core.lock.Lock()
-	// defer cw.core.lock.Unlock()
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 1\n")
-	go func() {
-		if err := cw.core.FlateWriter.Close(); err != nil {
-			panic(err)
-		}
-		// fmt.Printf("[ gzip.CompressionWriter.Close ] 2\n")
-	}()
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 3\n")
-	if _, err := io.Copy(cw.core.Writer, cw.core.FlateReader); err != nil {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.err != nil {
+		cw.core.FlateReader.Close()
+		cw.core.Writer.CloseWithError(cw.core.err)
+		return cw.core.err
+	}
+	if err := cw.core.FlateWriter.Close(); err != nil {
+		cw.core.FlateReader.Close()
+		return err
+	}
+	if err := <-cw.core.done; err != nil {
 		return err
 	}
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 4\n")
 	if err := cw.core.FlateReader.Close(); err != nil {
 		return err
 	}
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 5\n")
+	crc := cw.core.Crc.Sum32()
+	if c, ok := cw.core.FlateWriter.(checksummer); ok {
+		crc = c.Crc32()
+	}
 	trailer := make([]byte, 8)
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] crc: %v, size: %v\n", cw.core.Crc.Sum32(), cw.core.Size)
-	binary.LittleEndian.PutUint32(trailer[0:4], cw.core.Crc.Sum32())
+	// fmt.Printf("[ gzip.CompressionWriter.Close ] crc: %v, size: %v\n", crc, cw.core.Size)
+	binary.LittleEndian.PutUint32(trailer[0:4], crc)
 	binary.LittleEndian.PutUint32(trailer[4:8], cw.core.Size)
-	cw.core.Writer.Write(trailer)
-	// fmt.Printf("[ gzip.CompressionWriter.Close ] 6\n")
+	if _, err := cw.core.Writer.Write(trailer); err != nil {
+		return err
+	}
 	return cw.core.Writer.Close()
 }
 
 func (cr *CompressionReader) Read(p []byte) (int, error) {
-	// fmt.Printf("[ gzip.CompressionReader.Read ] 1\n")
-	// cr.core.lock.Lock()
-	// defer cr.core.lock.Unlock()
-
-	// fmt.Printf("[ gzip.CompressionReader.Read ] 2\n")
-	if n, err := cr.core.Reader.Read(p); err != nil {
-		// fmt.Printf("[ gzip.CompressionReader.Read ] error: %v\n", err)
-		return 0, err
-	} else {
-		// fmt.Printf("[ gzip.CompressionReader.Read ] bytes read: %v\n", n)
-		return n, err
-	}
+	return cr.core.Reader.Read(p)
 }
 
 func (cr *CompressionReader) Close() error {
-	// cr.core.lock.Lock()
-	// defer cr.core.lock.Unlock()
-	// fmt.Printf("[ gzip.CompressionReader.Close ] 1\n")
 	return cr.core.Reader.Close()
 }
@@ -3,22 +3,26 @@ package gzip
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash"
 	"hash/crc32"
 	"io"
-	"sync"
+
+	"github.com/FitrahHaque/Compression-Engine/compressor/flate"
+	"github.com/FitrahHaque/Compression-Engine/compressor/huffman"
 )
 
 type DecompressionCore struct {
-	lock           sync.Mutex
-	Writer         *io.PipeWriter
-	Reader         *io.PipeReader
-	IsHeaderParsed bool
-	Trailer        []byte
-	CurrentCrc     hash.Hash32
-	CurrentSize    uint32
-	FlateWriter    io.WriteCloser
-	FlateReader    io.ReadCloser
+	Writer      *io.PipeWriter
+	Reader      *io.PipeReader
+	InputWriter *io.PipeWriter
+	InputReader *io.PipeReader
+	Trailer     []byte
+	CurrentCrc  hash.Hash32
+	CurrentSize uint32
+	header      Header
+	headerErr   error
+	headerReady chan struct{}
 }
 
 type DecompressionWriter struct {
@@ -29,93 +33,101 @@ type DecompressionReader struct {
 	core *DecompressionCore
 }
 
-func NewDecompressionReaderAndWriter(flateReader io.ReadCloser, flateWriter io.WriteCloser) (io.ReadCloser, io.WriteCloser) {
+func NewDecompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
 	newDecompressionCore := new(DecompressionCore)
 	newDecompressionCore.Reader, newDecompressionCore.Writer = io.Pipe()
-	newDecompressionCore.FlateReader, newDecompressionCore.FlateWriter = flateReader, flateWriter
+	newDecompressionCore.InputReader, newDecompressionCore.InputWriter = io.Pipe()
 	newDecompressionCore.CurrentCrc = crc32.NewIEEE()
 	newDecompressionCore.Trailer = make([]byte, 8)
+	newDecompressionCore.headerReady = make(chan struct{})
 	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
 	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
+	go newDecompressionCore.pump()
 	return newDecompressionReader, newDecompressionWriter
 }
 
-func (dw *DecompressionWriter) Write(p []byte) (int, error) {
-	dw.core.lock.Lock()
-	// defer dw.core.lock.Unlock()
-	if !dw.core.IsHeaderParsed {
-		// header := p[:10]
-		dw.core.IsHeaderParsed = true
-		p = p[10:]
-	}
-	dw.core.lock.Unlock()
-	// fmt.Printf("[ gzip.DecompressionWriter.Write ] 1\n")
-	if len(dw.core.Trailer)+len(p) < 8 {
-		// fmt.Printf("[ gzip.DecompressionWriter.Write ] 2\n")
-		dw.core.Trailer = append(dw.core.Trailer, p...)
-	} else if len(p) < 8 {
-		// fmt.Printf("[ gzip.DecompressionWriter.Write ] 3\n")
-		dw.core.Trailer = append(dw.core.Trailer[len(p):], p...)
+// pump inflates the members as the compressed bytes arrive and passes the
+// output on, so neither side has to hold the whole file.
+func (core *DecompressionCore) pump() {
+	err := core.decompress()
+	if err != nil {
+		core.InputReader.CloseWithError(err)
 	} else {
-		copy(dw.core.Trailer, p[len(p)-8:])
-		// fmt.Printf("[ gzip.DecompressionWriter.Write ] len(Trailer): %v\n", len(dw.core.Trailer))
+		io.Copy(io.Discard, core.InputReader)
 	}
-	return dw.core.FlateWriter.Write(p)
+	core.Writer.CloseWithError(err)
 }
 
-func (dw *DecompressionWriter) Close() error {
-	dw.core.lock.Lock()
-	defer dw.core.lock.Unlock()
-
-	go func() {
-		if err := dw.core.FlateWriter.Close(); err != nil {
-			panic(err)
+// decompress reads the members one after another, as left by cat a.gz b.gz,
+// and checks each against its own trailer.
+func (core *DecompressionCore) decompress() error {
+	br := huffman.NewBitReader(core.InputReader)
+	core.header, core.headerErr = ReadHeader(br)
+	close(core.headerReady)
+	if core.headerErr != nil {
+		return core.headerErr
+	}
+	for member := 1; ; member++ {
+		if err := core.decompressMember(br); err != nil {
+			if member > 1 {
+				return fmt.Errorf("member %v: %w", member, err)
+			}
+			return err
+		}
+		if br.AtEOF() {
+			return nil
+		}
+		if _, err := ReadHeader(br); errors.Is(err, ErrNotGzip) {
+			// like gzip, bytes after the last member that do not start a new one are ignored
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("member %v: %w", member+1, err)
 		}
-	}()
+	}
+}
 
-	if _, err := io.Copy(dw.core.Writer, dw.core.FlateReader); err != nil {
+func (core *DecompressionCore) decompressMember(br *huffman.BitReader) error {
+	core.CurrentCrc.Reset()
+	if n, err := io.Copy(core.Writer, io.TeeReader(flate.NewInflateReader(br), core.CurrentCrc)); err != nil {
 		return err
+	} else {
+		core.CurrentSize = uint32(n)
 	}
-	if err := dw.core.FlateReader.Close(); err != nil {
+	br.AlignToByte()
+	if err := br.ReadAlignedBytes(core.Trailer); err != nil {
 		return err
 	}
-	return dw.core.Writer.Close()
+	givenCrc := binary.LittleEndian.Uint32(core.Trailer[0:4])
+	givenSize := binary.LittleEndian.Uint32(core.Trailer[4:])
+	// fmt.Printf("[ gzip.DecompressionCore.decompressMember ] givenCrc: %v, given Size: %v\n", givenCrc, givenSize)
+	// fmt.Printf("[ gzip.DecompressionCore.decompressMember ] currentCrc: %v, currentSize: %v\n", core.CurrentCrc.Sum32(), core.CurrentSize)
+	if givenSize != core.CurrentSize {
+		return errors.New("size did not match")
+	}
+	if givenCrc != core.CurrentCrc.Sum32() {
+		return errors.New("crc did not match")
+	}
+	return nil
+}
+
+func (dw *DecompressionWriter) Write(p []byte) (int, error) {
+	return dw.core.InputWriter.Write(p)
+}
+
+func (dw *DecompressionWriter) Close() error {
+	return dw.core.InputWriter.Close()
 }
 
 func (dr *DecompressionReader) Read(p []byte) (int, error) {
-	// dr.core.lock.Lock()
-	// defer dr.core.lock.Unlock()
+	return dr.core.Reader.Read(p)
+}
 
-	if n, err := dr.core.Reader.Read(p); err != nil {
-		return 0, err
-	} else {
-		dr.core.CurrentSize += uint32(n)
-		// if f, err := os.OpenFile("decom.o", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644); err != nil {
-		// 	panic(err)
-		// } else {
-		// 	f.Write(p)
-		// }
-		dr.core.CurrentCrc.Write(p[:n])
-		return n, nil
-	}
+// Header waits until the member header has been parsed and returns it.
+func (dr *DecompressionReader) Header() (Header, error) {
+	<-dr.core.headerReady
+	return dr.core.header, dr.core.headerErr
 }
 
 func (dr *DecompressionReader) Close() error {
-	dr.core.lock.Lock()
-	defer dr.core.lock.Unlock()
-
-	if len(dr.core.Trailer) != 8 {
-		return errors.New("trailer data is not sufficient")
-	}
-	givenCrc := binary.LittleEndian.Uint32(dr.core.Trailer[0:4])
-	givenSize := binary.LittleEndian.Uint32(dr.core.Trailer[4:])
-	// fmt.Printf("[ gzip.DecompressionReader.Close ] givenCrc: %v, given Size: %v\n", givenCrc, givenSize)
-	// fmt.Printf("[ gzip.DecompressionReader.Close ] currentCrc: %v, currentSize: %v\n", dr.core.CurrentCrc.Sum32(), dr.core.CurrentSize)
-	if givenSize != dr.core.CurrentSize {
-		return errors.New("size did not match")
-	}
-	if givenCrc != dr.core.CurrentCrc.Sum32() {
-		return errors.New("crc did not match")
-	}
 	return dr.core.Reader.Close()
 }
@@ -0,0 +1,232 @@
+package gzip
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"math"
+	"runtime"
+	"time"
+
+	"github.com/FitrahHaque/Compression-Engine/compressor/huffman"
+)
+
+const (
+	flagHcrc     = 1 << 1
+	flagExtra    = 1 << 2
+	flagName     = 1 << 3
+	flagComment  = 1 << 4
+	flagReserved = 0xe0
+
+	OSUnix    = 3
+	OSNTFS    = 11
+	OSUnknown = 255
+)
+
+// Header holds the member header fields of RFC 1952. Name and Comment are
+// stored as ISO 8859-1 on the wire, a zero ModTime is written as no time
+// stamp and OS is written as given.
+type Header struct {
+	Name    string
+	Comment string
+	ModTime time.Time
+	OS      byte
+	Extra   []ExtraField
+}
+
+// ExtraField is one SI1/SI2 tagged subfield of the FEXTRA area.
+type ExtraField struct {
+	ID   [2]byte
+	Data []byte
+}
+
+var ErrNotGzip = errors.New("not in gzip format, the magic bytes are missing")
+
+type headerReader struct {
+	bitReader *huffman.BitReader
+	crc       hash.Hash32
+}
+
+func (hr *headerReader) read(n int) ([]byte, error) {
+	data := make([]byte, n)
+	if err := hr.bitReader.ReadAlignedBytes(data); err != nil {
+		return nil, err
+	}
+	hr.crc.Write(data)
+	return data, nil
+}
+
+func (hr *headerReader) readString() (string, error) {
+	var runes []rune
+	for {
+		if b, err := hr.read(1); err != nil {
+			return "", err
+		} else if b[0] == 0 {
+			return string(runes), nil
+		} else {
+			// every ISO 8859-1 byte maps to the code point of the same value
+			runes = append(runes, rune(b[0]))
+		}
+	}
+}
+
+// ReadHeader parses one member header, verifying FHCRC when it is present.
+func ReadHeader(br *huffman.BitReader) (Header, error) {
+	var header Header
+	hr := &headerReader{
+		bitReader: br,
+		crc:       crc32.NewIEEE(),
+	}
+	if magic, err := hr.read(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return header, ErrNotGzip
+	}
+	fixed, err := hr.read(8)
+	if err != nil {
+		return header, err
+	}
+	// CM, FLG, MTIME, XFL and OS follow the magic bytes
+	if fixed[0] != 8 {
+		return header, fmt.Errorf("compression method %v is not deflate", fixed[0])
+	}
+	flg := fixed[1]
+	if flg&flagReserved != 0 {
+		return header, fmt.Errorf("reserved header flags %08b are set", flg&flagReserved)
+	}
+	if mtime := binary.LittleEndian.Uint32(fixed[2:6]); mtime > 0 {
+		header.ModTime = time.Unix(int64(mtime), 0)
+	}
+	header.OS = fixed[7]
+	if flg&flagExtra != 0 {
+		xlen, err := hr.read(2)
+		if err != nil {
+			return header, err
+		}
+		extra, err := hr.read(int(binary.LittleEndian.Uint16(xlen)))
+		if err != nil {
+			return header, err
+		}
+		if header.Extra, err = parseExtra(extra); err != nil {
+			return header, err
+		}
+	}
+	if flg&flagName != 0 {
+		if header.Name, err = hr.readString(); err != nil {
+			return header, err
+		}
+	}
+	if flg&flagComment != 0 {
+		if header.Comment, err = hr.readString(); err != nil {
+			return header, err
+		}
+	}
+	if flg&flagHcrc != 0 {
+		// the header CRC covers everything before it, so take the sum first
+		want := uint16(hr.crc.Sum32())
+		if given, err := hr.read(2); err != nil {
+			return header, err
+		} else if binary.LittleEndian.Uint16(given) != want {
+			return header, errors.New("header crc did not match")
+		}
+	}
+	return header, nil
+}
+
+func parseExtra(extra []byte) ([]ExtraField, error) {
+	var fields []ExtraField
+	for len(extra) > 0 {
+		if len(extra) < 4 {
+			return nil, errors.New("extra field is truncated")
+		}
+		length := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+length {
+			return nil, errors.New("extra subfield is longer than the extra field")
+		}
+		fields = append(fields, ExtraField{
+			ID:   [2]byte{extra[0], extra[1]},
+			Data: extra[4 : 4+length],
+		})
+		extra = extra[4+length:]
+	}
+	return fields, nil
+}
+
+// HostOS is the OS byte for files compressed on this machine.
+func HostOS() byte {
+	if runtime.GOOS == "windows" {
+		return OSNTFS
+	}
+	return OSUnix
+}
+
+func (h Header) bytes(xfl byte) ([]byte, error) {
+	var flg byte
+	var mtime uint32
+	if !h.ModTime.IsZero() {
+		if seconds := h.ModTime.Unix(); seconds < 0 || seconds > math.MaxUint32 {
+			return nil, fmt.Errorf("modification time %v cannot be stored in a gzip header", h.ModTime)
+		} else {
+			mtime = uint32(seconds)
+		}
+	}
+	var extra []byte
+	for _, field := range h.Extra {
+		if len(field.Data) > math.MaxUint16 {
+			return nil, fmt.Errorf("extra subfield %q is too long", field.ID[:])
+		}
+		extra = append(extra, field.ID[:]...)
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(len(field.Data)))
+		extra = append(extra, field.Data...)
+	}
+	if len(extra) > math.MaxUint16 {
+		return nil, errors.New("extra field is too long")
+	}
+	if h.Extra != nil {
+		flg |= flagExtra
+	}
+	name, err := latin1(h.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(name) > 0 {
+		flg |= flagName
+	}
+	comment, err := latin1(h.Comment)
+	if err != nil {
+		return nil, err
+	}
+	if len(comment) > 0 {
+		flg |= flagComment
+	}
+
+	output := []byte{
+		0x1f, 0x8b, // ID1, ID2
+		0x08, // CM = deflate
+		flg,
+	}
+	output = binary.LittleEndian.AppendUint32(output, mtime)
+	output = append(output, xfl, h.OS)
+	if flg&flagExtra != 0 {
+		output = binary.LittleEndian.AppendUint16(output, uint16(len(extra)))
+		output = append(output, extra...)
+	}
+	if flg&flagName != 0 {
+		output = append(append(output, name...), 0)
+	}
+	if flg&flagComment != 0 {
+		output = append(append(output, comment...), 0)
+	}
+	return output, nil
+}
+
+func latin1(s string) ([]byte, error) {
+	var output []byte
+	for _, r := range s {
+		if r == 0 || r > 0xff {
+			return nil, fmt.Errorf("%q cannot be stored as a zero-terminated ISO 8859-1 string", s)
+		}
+		output = append(output, byte(r))
+	}
+	return output, nil
+}
@@ -0,0 +1,181 @@
+package gzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/FitrahHaque/Compression-Engine/compressor/flate"
+	"github.com/FitrahHaque/Compression-Engine/compressor/huffman"
+)
+
+// a resync point has to decode at least two whole blocks and this much
+// output, or run into a trailer, before it is believed
+const minResyncOutput = 32 * 1024
+
+// LostRange is a stretch of the compressed input that could not be decoded.
+// Start and End are byte offsets, End is where decoding picked up again.
+type LostRange struct {
+	Start      int64
+	End        int64
+	FailureBit int64
+	Output     int64
+	Err        error
+}
+
+type RecoveryReport struct {
+	Members   int
+	Recovered int64
+	// Zeroed counts output bytes that referred straight back into lost data
+	// and were written as zeros. Later matches copying them repeat the zeros.
+	Zeroed int64
+	// Mismatched lists the members that decoded but failed their CRC or size check
+	Mismatched []int
+	Lost       []LostRange
+}
+
+// Recover decodes as much of a damaged gzip file as it can. Everything
+// decoded before a failure is written out, then the input is scanned for the
+// next member header or deflate block that decodes cleanly and decoding
+// carries on from there. Only a failing output writer stops it early.
+func Recover(data []byte, output io.Writer) (RecoveryReport, error) {
+	var report RecoveryReport
+	counter := &countingWriter{writer: output}
+	scratch := flate.NewInflateReader(nil)
+	bit, atMember := int64(0), true
+	for bit < int64(len(data))*8 {
+		br := bitReaderAt(data, bit)
+		start := bit - br.BitOffset()
+		var err error
+		if atMember {
+			report.Members++
+			if _, err = ReadHeader(br); err != nil {
+				// a broken header loses the member from its first byte
+				br = bitReaderAt(data, bit)
+			}
+		}
+		var inflateReader *flate.InflateReader
+		crc := crc32.NewIEEE()
+		var size int64
+		if err == nil {
+			inflateReader = flate.NewInflateReader(br)
+			if !atMember {
+				inflateReader.AllowMissingHistory()
+			}
+			size, err = io.Copy(io.MultiWriter(counter, crc), inflateReader)
+			report.Zeroed += inflateReader.MissingBytes()
+			if counter.err != nil {
+				report.Recovered = counter.written
+				return report, counter.err
+			}
+		}
+		if err == nil {
+			br.AlignToByte()
+			trailer := make([]byte, 8)
+			if err = br.ReadAlignedBytes(trailer); err == nil && atMember {
+				// a member picked up halfway cannot be checked against its trailer
+				if binary.LittleEndian.Uint32(trailer[0:4]) != crc.Sum32() || binary.LittleEndian.Uint32(trailer[4:8]) != uint32(size) {
+					report.Mismatched = append(report.Mismatched, report.Members)
+				}
+			}
+		}
+		if err == nil {
+			bit, atMember = start+br.BitOffset(), true
+			continue
+		}
+		failure := start + br.BitOffset()
+		next, isMember := resync(data, failure+1, scratch)
+		lost := LostRange{
+			Start:      failure / 8,
+			End:        int64(len(data)),
+			FailureBit: failure,
+			Output:     counter.written,
+			Err:        err,
+		}
+		if next < 0 {
+			report.Lost = append(report.Lost, lost)
+			break
+		}
+		lost.End = next / 8
+		report.Lost = append(report.Lost, lost)
+		bit, atMember = next, isMember
+	}
+	report.Recovered = counter.written
+	return report, nil
+}
+
+// resync looks for the first bit offset from which decoding works again,
+// trying a member header at every byte boundary and a deflate block at
+// every bit. It returns -1 when there is none.
+func resync(data []byte, from int64, scratch *flate.InflateReader) (int64, bool) {
+	buffer := make([]byte, 4096)
+	for bit := from; bit < int64(len(data))*8; bit++ {
+		if bit%8 == 0 && isMemberStart(data[bit/8:]) {
+			return bit, true
+		}
+		if isBlockStart(data, bit, scratch, buffer) {
+			return bit, false
+		}
+	}
+	return -1, false
+}
+
+func isMemberStart(data []byte) bool {
+	if len(data) < 3 || data[0] != 0x1f || data[1] != 0x8b || data[2] != 8 {
+		return false
+	}
+	_, err := ReadHeader(huffman.NewBitReader(bytes.NewReader(data)))
+	return err == nil
+}
+
+func isBlockStart(data []byte, bit int64, scratch *flate.InflateReader, buffer []byte) bool {
+	br := bitReaderAt(data, bit)
+	scratch.Reset(br)
+	scratch.AllowMissingHistory()
+	decoded := 0
+	for {
+		n, err := scratch.Read(buffer)
+		decoded += n
+		if err == io.EOF {
+			// the final block has to be followed by a trailer and then the
+			// end of the file or another member
+			end := (bit/8*8+br.BitOffset()+7)/8 + 8
+			return end == int64(len(data)) || end+1 < int64(len(data)) && data[end] == 0x1f && data[end+1] == 0x8b
+		} else if err != nil {
+			return false
+		}
+		// the header of a third block means two have been decoded whole
+		if len(scratch.BlockTypes()) > 2 && decoded >= minResyncOutput {
+			return true
+		}
+	}
+}
+
+// bitReaderAt starts reading data at the given bit offset. The reader's
+// BitOffset counts from the start of that byte.
+func bitReaderAt(data []byte, bit int64) *huffman.BitReader {
+	br := huffman.NewBitReader(bytes.NewReader(data[bit/8:]))
+	if bit%8 > 0 {
+		br.ReadBits(uint(bit % 8))
+	}
+	return br
+}
+
+type countingWriter struct {
+	writer  io.Writer
+	written int64
+	err     error
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.writer.Write(p)
+	cw.written += int64(n)
+	if err != nil {
+		cw.err = err
+	} else if n < len(p) {
+		cw.err = errors.New("short write")
+	}
+	return n, cw.err
+}
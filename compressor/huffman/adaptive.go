@@ -0,0 +1,328 @@
+package huffman
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// adaptiveEOF follows the last byte, the stream has no length up front
+	adaptiveEOF     = alphabetSize
+	adaptiveSymbols = alphabetSize + 1
+	adaptiveRawBits = 9
+	// every symbol and the NYT leaf, with the internal nodes joining them
+	adaptiveMaxNodes = 2*adaptiveSymbols + 1
+	adaptiveRoot     = adaptiveMaxNodes - 1
+)
+
+type adaptiveNode struct {
+	weight              int64
+	parent, left, right int
+	symbol              int
+}
+
+// adaptiveTree is the FGK code tree. Nodes are stored by their number, which
+// grows with weight so that siblings sit next to each other. The root holds
+// the highest number and new leaves split off the NYT (not yet transmitted)
+// leaf at the bottom.
+type adaptiveTree struct {
+	nodes  [adaptiveMaxNodes]adaptiveNode
+	leafOf [adaptiveSymbols]int
+	nyt    int
+	path   []uint32
+}
+
+func newAdaptiveTree() *adaptiveTree {
+	tree := new(adaptiveTree)
+	for symbol := range tree.leafOf {
+		tree.leafOf[symbol] = -1
+	}
+	tree.nyt = adaptiveRoot
+	tree.nodes[adaptiveRoot] = adaptiveNode{parent: -1, left: -1, right: -1, symbol: -1}
+	return tree
+}
+
+func (tree *adaptiveTree) isLeaf(n int) bool {
+	return tree.nodes[n].left < 0
+}
+
+// encode writes the code of symbol as it stands, or the code of the NYT leaf
+// followed by the symbol itself the first time it is seen.
+func (tree *adaptiveTree) encode(bw *BitWriter, symbol int) error {
+	n := tree.leafOf[symbol]
+	if n < 0 {
+		n = tree.nyt
+	}
+	tree.path = tree.path[:0]
+	for ; n != adaptiveRoot; n = tree.nodes[n].parent {
+		if tree.nodes[tree.nodes[n].parent].right == n {
+			tree.path = append(tree.path, 1)
+		} else {
+			tree.path = append(tree.path, 0)
+		}
+	}
+	for i := len(tree.path) - 1; i >= 0; i-- {
+		if err := bw.WriteBits(tree.path[i], 1); err != nil {
+			return err
+		}
+	}
+	if tree.leafOf[symbol] < 0 {
+		if err := bw.WriteBits(uint32(symbol), adaptiveRawBits); err != nil {
+			return err
+		}
+	}
+	tree.update(symbol)
+	return nil
+}
+
+// update counts one more of symbol. Every node on the way up is first swapped
+// with the highest numbered node of the same weight, which keeps the numbers
+// in weight order once its weight goes up.
+func (tree *adaptiveTree) update(symbol int) {
+	q := tree.leafOf[symbol]
+	if q < 0 {
+		old := tree.nyt
+		tree.nodes[old].left, tree.nodes[old].right = old-2, old-1
+		tree.nodes[old-1] = adaptiveNode{parent: old, left: -1, right: -1, symbol: symbol}
+		tree.nodes[old-2] = adaptiveNode{parent: old, left: -1, right: -1, symbol: -1}
+		tree.nodes[old].symbol = -1
+		tree.nyt, tree.leafOf[symbol] = old-2, old-1
+		q = old - 1
+	}
+	for q >= 0 {
+		leader := q
+		for n := q + 1; n <= adaptiveRoot && tree.nodes[n].weight == tree.nodes[q].weight; n++ {
+			leader = n
+		}
+		if leader != q && leader != tree.nodes[q].parent {
+			tree.swap(q, leader)
+			q = leader
+		}
+		tree.nodes[q].weight++
+		q = tree.nodes[q].parent
+	}
+}
+
+// swap exchanges the subtrees numbered a and b, the numbers stay in place.
+func (tree *adaptiveTree) swap(a, b int) {
+	parentA, parentB := tree.nodes[a].parent, tree.nodes[b].parent
+	tree.nodes[a], tree.nodes[b] = tree.nodes[b], tree.nodes[a]
+	tree.nodes[a].parent, tree.nodes[b].parent = parentA, parentB
+	for _, n := range []int{a, b} {
+		if node := tree.nodes[n]; !tree.isLeaf(n) {
+			tree.nodes[node.left].parent, tree.nodes[node.right].parent = n, n
+		} else if node.symbol >= 0 {
+			tree.leafOf[node.symbol] = n
+		} else {
+			tree.nyt = n
+		}
+	}
+}
+
+type AdaptiveCompressionWriter struct {
+	core *adaptiveCompressionCore
+}
+
+type AdaptiveCompressionReader struct {
+	core *adaptiveCompressionCore
+}
+
+type adaptiveCompressionCore struct {
+	lock      sync.Mutex
+	Writer    *io.PipeWriter
+	Reader    *io.PipeReader
+	bitWriter *BitWriter
+	tree      *adaptiveTree
+	isClosed  bool
+}
+
+// NewAdaptiveCompressionReaderAndWriter codes every byte as it is written
+// with a tree that learns the frequencies on the way, so nothing is buffered
+// and no table is stored. The whole bytes of output are readable as soon as
+// each Write returns.
+func NewAdaptiveCompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
+	newCompressionCore := new(adaptiveCompressionCore)
+	newCompressionCore.Reader, newCompressionCore.Writer = io.Pipe()
+	newCompressionCore.bitWriter = NewBitWriter(newCompressionCore.Writer)
+	newCompressionCore.bitWriter.WriteBits(uint32(modeAdaptive), 8)
+	newCompressionCore.tree = newAdaptiveTree()
+	newCompressionReader, newCompressionWriter := new(AdaptiveCompressionReader), new(AdaptiveCompressionWriter)
+	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
+	return newCompressionReader, newCompressionWriter
+}
+
+func (cw *AdaptiveCompressionWriter) Write(data []byte) (int, error) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return 0, errors.New("cannot write to a closed compression stream")
+	}
+	for i, b := range data {
+		if err := cw.core.tree.encode(cw.core.bitWriter, int(b)); err != nil {
+			return i, err
+		}
+	}
+	cw.core.bitWriter.flushBuffer()
+	return len(data), cw.core.bitWriter.err
+}
+
+func (cw *AdaptiveCompressionWriter) Close() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.isClosed {
+		return errors.New("compression stream is already closed")
+	}
+	cw.core.isClosed = true
+	err := cw.core.tree.encode(cw.core.bitWriter, adaptiveEOF)
+	if err == nil {
+		err = cw.core.bitWriter.Flush()
+	}
+	cw.core.Writer.CloseWithError(err)
+	return err
+}
+
+func (cr *AdaptiveCompressionReader) Read(data []byte) (int, error) {
+	return cr.core.Reader.Read(data)
+}
+
+func (cr *AdaptiveCompressionReader) Close() error {
+	return cr.core.Reader.Close()
+}
+
+// adaptiveReader decodes an adaptive stream, mode byte included, up to its
+// end symbol. A code can be left halfway when the input runs dry, so a Read
+// hands back what has arrived instead of waiting to fill data.
+type adaptiveReader struct {
+	bitReader *BitReader
+	tree      *adaptiveTree
+	node      int
+	isStarted bool
+	err       error
+}
+
+func newAdaptiveReader(bitReader *BitReader) *adaptiveReader {
+	return &adaptiveReader{
+		bitReader: bitReader,
+		tree:      newAdaptiveTree(),
+		node:      adaptiveRoot,
+	}
+}
+
+func (ar *adaptiveReader) Read(data []byte) (int, error) {
+	if ar.err != nil {
+		return 0, ar.err
+	}
+	if !ar.isStarted {
+		ar.isStarted = true
+		if mode, err := ar.bitReader.ReadBits(8); err != nil {
+			ar.err = err
+			return 0, err
+		} else if byte(mode) != modeAdaptive {
+			ar.err = fmt.Errorf("huffman mode %v is not adaptive", mode)
+			return 0, ar.err
+		}
+	}
+	i := 0
+	for i < len(data) {
+		if !ar.tree.isLeaf(ar.node) {
+			if i > 0 && ar.bitReader.Buffered() == 0 {
+				return i, nil
+			}
+			if bit, err := ar.bitReader.ReadBits(1); err != nil {
+				ar.err = err
+				return i, err
+			} else if bit == 1 {
+				ar.node = ar.tree.nodes[ar.node].right
+			} else {
+				ar.node = ar.tree.nodes[ar.node].left
+			}
+			continue
+		}
+		symbol := ar.tree.nodes[ar.node].symbol
+		if ar.node == ar.tree.nyt {
+			if i > 0 && ar.bitReader.Buffered() < adaptiveRawBits {
+				return i, nil
+			}
+			if raw, err := ar.bitReader.ReadBits(adaptiveRawBits); err != nil {
+				ar.err = err
+				return i, err
+			} else if symbol = int(raw); symbol >= adaptiveSymbols || ar.tree.leafOf[symbol] >= 0 {
+				ar.err = fmt.Errorf("invalid new symbol %v in the adaptive huffman stream", symbol)
+				return i, ar.err
+			}
+		}
+		ar.tree.update(symbol)
+		ar.node = adaptiveRoot
+		if symbol == adaptiveEOF {
+			ar.err = io.EOF
+			return i, io.EOF
+		}
+		data[i] = byte(symbol)
+		i++
+	}
+	return i, nil
+}
+
+type AdaptiveDecompressionWriter struct {
+	core *adaptiveDecompressionCore
+}
+
+type AdaptiveDecompressionReader struct {
+	core *adaptiveDecompressionCore
+}
+
+type adaptiveDecompressionCore struct {
+	isStreamEnded bool
+	lock          sync.Mutex
+	inputReader   *io.PipeReader
+	inputWriter   *io.PipeWriter
+	reader        *adaptiveReader
+}
+
+// NewAdaptiveDecompressionReaderAndWriter decodes the stream as it arrives,
+// bytes are readable as soon as their codes have been written.
+func NewAdaptiveDecompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
+	newDecompressionCore := new(adaptiveDecompressionCore)
+	newDecompressionCore.inputReader, newDecompressionCore.inputWriter = io.Pipe()
+	newDecompressionCore.reader = newAdaptiveReader(NewBitReader(newDecompressionCore.inputReader))
+	newDecompressionReader, newDecompressionWriter := new(AdaptiveDecompressionReader), new(AdaptiveDecompressionWriter)
+	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
+	return newDecompressionReader, newDecompressionWriter
+}
+
+func (dr *AdaptiveDecompressionReader) Read(data []byte) (int, error) {
+	n, err := dr.core.reader.Read(data)
+	if err == io.EOF {
+		dr.core.lock.Lock()
+		dr.core.isStreamEnded = true
+		dr.core.lock.Unlock()
+		dr.core.inputReader.Close()
+	} else if err != nil {
+		dr.core.inputReader.CloseWithError(err)
+	}
+	return n, err
+}
+
+func (dr *AdaptiveDecompressionReader) Close() error {
+	return dr.core.inputReader.Close()
+}
+
+func (dw *AdaptiveDecompressionWriter) Write(data []byte) (int, error) {
+	if n, err := dw.core.inputWriter.Write(data); err != nil {
+		dw.core.lock.Lock()
+		defer dw.core.lock.Unlock()
+		// whatever follows the end symbol is not part of the stream
+		if dw.core.isStreamEnded {
+			return len(data), nil
+		}
+		return n, err
+	} else {
+		return n, nil
+	}
+}
+
+func (dw *AdaptiveDecompressionWriter) Close() error {
+	return dw.core.inputWriter.Close()
+}
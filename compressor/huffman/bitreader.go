@@ -0,0 +1,154 @@
+package huffman
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BitReader reads LSB-first bit streams (the DEFLATE bit order) through a
+// 64-bit holder that is refilled a byte at a time.
+type BitReader struct {
+	reader    io.ByteReader
+	bitsHold  uint64
+	bitsCount uint
+	bytesRead int64
+	err       error
+}
+
+func NewBitReader(reader io.Reader) *BitReader {
+	byteReader, ok := reader.(io.ByteReader)
+	if !ok {
+		byteReader = bufio.NewReader(reader)
+	}
+	return &BitReader{
+		reader: byteReader,
+	}
+}
+
+func (br *BitReader) refill() {
+	for br.bitsCount <= 56 && br.err == nil {
+		b, err := br.reader.ReadByte()
+		if err != nil {
+			br.err = err
+			return
+		}
+		br.bitsHold |= uint64(b) << br.bitsCount
+		br.bitsCount += 8
+		br.bytesRead++
+	}
+}
+
+// refillBuffered refills like refill but stops at what the underlying reader
+// already holds, so a live stream is not waited on for bits it may never
+// send. Readers that cannot tell are refilled in full.
+func (br *BitReader) refillBuffered() {
+	available, ok := 0, false
+	switch reader := br.reader.(type) {
+	case interface{ Buffered() int }:
+		available, ok = reader.Buffered(), true
+	case interface{ Len() int }:
+		available, ok = reader.Len(), true
+	}
+	if !ok {
+		br.refill()
+		return
+	}
+	for ; br.bitsCount <= 56 && available > 0 && br.err == nil; available-- {
+		br.readByte()
+	}
+}
+
+// readByte moves one more byte into the holder, waiting for it if need be.
+func (br *BitReader) readByte() bool {
+	b, err := br.reader.ReadByte()
+	if err != nil {
+		br.err = err
+		return false
+	}
+	br.bitsHold |= uint64(b) << br.bitsCount
+	br.bitsCount += 8
+	br.bytesRead++
+	return true
+}
+
+func (br *BitReader) eofError() error {
+	if br.err == io.EOF {
+		return fmt.Errorf("not enough bits to read from the compressed data: %w", io.ErrUnexpectedEOF)
+	}
+	return fmt.Errorf("not enough bits to read from the compressed data: %w", br.err)
+}
+
+func (br *BitReader) ReadBits(nbits uint) (uint32, error) {
+	if nbits > 32 {
+		return 0, errors.New("cannot read more than 32 bits at once")
+	}
+	// only the bytes needed, a live stream may not have the rest yet
+	for br.bitsCount < nbits {
+		if br.err != nil || !br.readByte() {
+			return 0, br.eofError()
+		}
+	}
+	output := uint32(br.bitsHold & (1<<nbits - 1))
+	br.bitsHold >>= nbits
+	br.bitsCount -= nbits
+	return output, nil
+}
+
+// AlignToByte drops the bits left over in the current byte.
+func (br *BitReader) AlignToByte() {
+	n := br.bitsCount % 8
+	br.bitsHold >>= n
+	br.bitsCount -= n
+}
+
+func (br *BitReader) ReadAlignedBytes(data []byte) error {
+	if br.bitsCount%8 != 0 {
+		return errors.New("bit reader is not aligned to a byte boundary")
+	}
+	i := 0
+	for ; i < len(data) && br.bitsCount > 0; i++ {
+		data[i] = byte(br.bitsHold)
+		br.bitsHold >>= 8
+		br.bitsCount -= 8
+	}
+	for ; i < len(data); i++ {
+		b, err := br.reader.ReadByte()
+		if err != nil {
+			br.err = err
+			return br.eofError()
+		}
+		data[i] = b
+		br.bytesRead++
+	}
+	return nil
+}
+
+// AtEOF reports whether every bit has been consumed and the underlying
+// reader has nothing more to give.
+func (br *BitReader) AtEOF() bool {
+	if br.bitsCount > 0 {
+		return false
+	}
+	br.refill()
+	return br.bitsCount == 0 && br.err == io.EOF
+}
+
+// Buffered is the number of bits that can be read without waiting on the
+// underlying reader.
+func (br *BitReader) Buffered() int {
+	n := int(br.bitsCount)
+	switch reader := br.reader.(type) {
+	case interface{ Buffered() int }:
+		n += 8 * reader.Buffered()
+	case interface{ Len() int }:
+		n += 8 * reader.Len()
+	}
+	return n
+}
+
+// BitOffset is the number of bits consumed from the underlying reader so far.
+func (br *BitReader) BitOffset() int64 {
+	return br.bytesRead*8 - int64(br.bitsCount)
+}
@@ -0,0 +1,60 @@
+package huffman
+
+import (
+	"errors"
+	"io"
+)
+
+const bitWriterBufferSize = 4096
+
+// BitWriter writes LSB-first bit streams, the order BitReader reads them in.
+// Whole bytes are gathered in a buffer and handed to the writer in batches.
+type BitWriter struct {
+	writer    io.Writer
+	bitsHold  uint64
+	bitsCount uint
+	buffer    []byte
+	err       error
+}
+
+func NewBitWriter(writer io.Writer) *BitWriter {
+	return &BitWriter{
+		writer: writer,
+		buffer: make([]byte, 0, bitWriterBufferSize),
+	}
+}
+
+// WriteBits writes the low nbits of value, least significant bit first.
+func (bw *BitWriter) WriteBits(value uint32, nbits uint) error {
+	if nbits > 32 {
+		return errors.New("cannot write more than 32 bits at once")
+	}
+	bw.bitsHold |= (uint64(value) & (1<<nbits - 1)) << bw.bitsCount
+	bw.bitsCount += nbits
+	for bw.bitsCount >= 8 {
+		bw.buffer = append(bw.buffer, byte(bw.bitsHold))
+		bw.bitsHold >>= 8
+		bw.bitsCount -= 8
+	}
+	if len(bw.buffer) >= bitWriterBufferSize {
+		bw.flushBuffer()
+	}
+	return bw.err
+}
+
+// Flush pads the last byte with zero bits and writes out everything held.
+func (bw *BitWriter) Flush() error {
+	if bw.bitsCount > 0 {
+		bw.buffer = append(bw.buffer, byte(bw.bitsHold))
+		bw.bitsHold, bw.bitsCount = 0, 0
+	}
+	bw.flushBuffer()
+	return bw.err
+}
+
+func (bw *BitWriter) flushBuffer() {
+	if bw.err == nil && len(bw.buffer) > 0 {
+		_, bw.err = bw.writer.Write(bw.buffer)
+	}
+	bw.buffer = bw.buffer[:0]
+}
@@ -0,0 +1,173 @@
+package huffman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const (
+	defaultChunkSize = 64 * 1024
+	// a table takes up to 160 bytes, smaller chunks cannot pay for theirs
+	minChunkSize = 1024
+
+	chunkNewTable   byte = 0
+	chunkReuseTable byte = 1
+)
+
+type chunk struct {
+	length  int
+	table   []byte
+	payload []byte
+}
+
+// compressChunked codes the input in chunks with a table each, so a table
+// can follow the data as it changes. A chunk whose bytes all have codes in
+// the table before it reuses that table when that comes out smaller. Every
+// chunk is stored as its length, a table flag, the table when it is new, the
+// size of the coded data and then the data, which starts on a byte of its own.
+func compressChunked(content []byte, chunkSize int, output io.Writer) error {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	chunkSize = max(chunkSize, minChunkSize)
+	if _, err := output.Write(writeHeader(modeChunked, len(content))); err != nil {
+		return err
+	}
+	var previous []CanonicalHuffman
+	for start := 0; start < len(content); start += chunkSize {
+		data := content[start:min(start+chunkSize, len(content))]
+		var symbolFreq [alphabetSize]int
+		for _, b := range data {
+			symbolFreq[b]++
+		}
+		codes, err := BuildCanonicalHuffmanEncoder(symbolFreq[:], maxCodeLength)
+		if err != nil {
+			return err
+		}
+		lengths := codeLengths(codes)
+		table := packLengths(lengths)
+		header := binary.AppendUvarint(nil, uint64(len(data)))
+		if previous != nil && codedBits(symbolFreq, codeLengths(previous)) <= codedBits(symbolFreq, lengths)+len(table)*8 {
+			codes = previous
+			header = append(header, chunkReuseTable)
+		} else {
+			header = append(append(header, chunkNewTable), table...)
+		}
+		var payload bytes.Buffer
+		if err := encode(codes, data, &payload); err != nil {
+			return err
+		}
+		header = binary.AppendUvarint(header, uint64(payload.Len()))
+		if _, err := output.Write(header); err != nil {
+			return err
+		}
+		if _, err := payload.WriteTo(output); err != nil {
+			return err
+		}
+		previous = codes
+	}
+	return nil
+}
+
+func codeLengths(codes []CanonicalHuffman) [alphabetSize]int {
+	var lengths [alphabetSize]int
+	for symbol, code := range codes {
+		if code != nil {
+			lengths[symbol] = code.GetLength()
+		}
+	}
+	return lengths
+}
+
+// codedBits is the size of the data under the given code lengths, or more
+// than any real size when some byte has no code.
+func codedBits(symbolFreq [alphabetSize]int, lengths [alphabetSize]int) int {
+	bits := 0
+	for symbol, freq := range symbolFreq {
+		if freq > 0 && lengths[symbol] == 0 {
+			return int(^uint(0) >> 2)
+		}
+		bits += freq * lengths[symbol]
+	}
+	return bits
+}
+
+// decompressChunked finds every chunk and its table by walking the chunk
+// headers, then decodes the chunks side by side. Tables are only checked on
+// the way, each worker builds the decoder for its own chunk.
+func decompressChunked(content []byte, length int) ([]byte, error) {
+	var chunks []chunk
+	var previous []byte
+	for total := 0; total < length; {
+		chunkLength, n := binary.Uvarint(content)
+		if n <= 0 || chunkLength == 0 || chunkLength > uint64(length-total) {
+			return nil, fmt.Errorf("chunk %v has an invalid length", len(chunks))
+		}
+		content = content[n:]
+		if len(content) == 0 {
+			return nil, fmt.Errorf("chunk %v is truncated", len(chunks))
+		}
+		flag := content[0]
+		content = content[1:]
+		switch flag {
+		case chunkNewTable:
+			_, rest, err := unpackLengths(content)
+			if err != nil {
+				return nil, fmt.Errorf("table of chunk %v: %w", len(chunks), err)
+			}
+			previous, content = content[:len(content)-len(rest)], rest
+		case chunkReuseTable:
+			if previous == nil {
+				return nil, errors.New("first chunk has no table to reuse")
+			}
+		default:
+			return nil, fmt.Errorf("chunk %v has an invalid table flag %v", len(chunks), flag)
+		}
+		size, n := binary.Uvarint(content)
+		if n <= 0 || size > uint64(len(content)-n) {
+			return nil, fmt.Errorf("chunk %v is truncated", len(chunks))
+		}
+		content = content[n:]
+		// every symbol takes at least one bit, so the chunk lengths that add
+		// up to the output are bounded by the data before it is allocated
+		if chunkLength > size*8 {
+			return nil, fmt.Errorf("chunk %v is too short for %v bytes", len(chunks), chunkLength)
+		}
+		chunks = append(chunks, chunk{
+			length:  int(chunkLength),
+			table:   previous,
+			payload: content[:size],
+		})
+		content = content[size:]
+		total += int(chunkLength)
+	}
+	output := make([]byte, length)
+	errs := make([]error, len(chunks))
+	workers := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	start := 0
+	for i, c := range chunks {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, c chunk, output []byte) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			lengths, _, _ := unpackLengths(c.table)
+			decoder, err := BuildTableDecoder(lengths[:])
+			if err == nil {
+				err = decodeInto(decoder, c.payload, output)
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("chunk %v: %w", i, err)
+			}
+		}(i, c, output[start:start+c.length])
+		start += c.length
+	}
+	wg.Wait()
+	return output, errors.Join(errs...)
+}
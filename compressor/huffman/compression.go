@@ -3,12 +3,7 @@ package huffman
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
-	"os"
-	"slices"
-	"strconv"
-	"strings"
 	"sync"
 )
 
@@ -21,16 +16,23 @@ type CompressionReader struct {
 
 type compressionCore struct {
 	isInputBufferClosed bool
+	err                 error
+	cond                *sync.Cond
 	lock                sync.Mutex
 	inputBuffer         io.ReadWriter
 	outputBuffer        io.ReadWriter
+	mode                byte
+	chunkSize           int
 }
 
 func (cr *CompressionReader) Read(data []byte) (int, error) {
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
-	if !cr.core.isInputBufferClosed {
-		return 0, errors.New("input buffer not closed")
+	for !cr.core.isInputBufferClosed {
+		cr.core.cond.Wait()
+	}
+	if cr.core.err != nil {
+		return 0, cr.core.err
 	}
 	return cr.core.outputBuffer.Read(data)
 }
@@ -55,100 +57,96 @@ func (cw *CompressionWriter) Write(data []byte) (int, error) {
 func (cw *CompressionWriter) Close() error {
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
+	// the reader waits until the whole input has been processed
+	defer cw.core.cond.Broadcast()
 	cw.core.isInputBufferClosed = true
 	originalData, err := io.ReadAll(cw.core.inputBuffer)
 	// fmt.Printf("[ DecompressionWriter.Close ] compressedData: %v\n", compressedData)
 	if err != nil {
+		cw.core.err = err
 		return err
 	}
-	compressedData := compress(originalData)
-	if _, err = cw.core.outputBuffer.Write(compressedData); err != nil {
+	if cw.core.mode == modeContext {
+		err = compressContext(originalData, cw.core.outputBuffer)
+	} else if cw.core.mode == modeChunked {
+		err = compressChunked(originalData, cw.core.chunkSize, cw.core.outputBuffer)
+	} else {
+		err = compress(originalData, cw.core.outputBuffer)
+	}
+	if err != nil {
+		cw.core.err = err
 		return err
 	}
 	return nil
 }
 
 func NewCompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
+	return newCompressionReaderAndWriter(modeStatic)
+}
+
+// NewContextCompressionReaderAndWriter codes every byte with a table chosen
+// by the byte before it.
+func NewContextCompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
+	return newCompressionReaderAndWriter(modeContext)
+}
+
+// NewChunkedCompressionReaderAndWriter gives every chunkSize bytes of input
+// a table of their own, a non-positive size picks the default and sizes under
+// 1 KiB are raised to it.
+func NewChunkedCompressionReaderAndWriter(chunkSize int) (io.ReadCloser, io.WriteCloser) {
+	reader, writer := newCompressionReaderAndWriter(modeChunked)
+	writer.(*CompressionWriter).core.chunkSize = chunkSize
+	return reader, writer
+}
+
+func newCompressionReaderAndWriter(mode byte) (io.ReadCloser, io.WriteCloser) {
 	newCompressionCore := new(compressionCore)
+	newCompressionCore.mode = mode
 	newCompressionCore.inputBuffer, newCompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
 	newCompressionCore.isInputBufferClosed = false
+	newCompressionCore.cond = sync.NewCond(&newCompressionCore.lock)
 	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
 	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
 	return newCompressionReader, newCompressionWriter
 }
 
-func compress(content []byte) []byte {
-	contentString := string(content)
-	symbolFreq := make(map[rune]int)
-	for _, c := range contentString {
-		symbolFreq[c]++
+func compress(content []byte, output io.Writer) error {
+	var symbolFreq [alphabetSize]int
+	for _, b := range content {
+		symbolFreq[b]++
 	}
-	var compressionHeader strings.Builder
-	for key, val := range symbolFreq {
-		if key == 10 {
-			fmt.Fprintf(&compressionHeader, "%s|\\n", strconv.Itoa(val))
-		} else {
-			fmt.Fprintf(&compressionHeader, "%s|%s", strconv.Itoa(val), string(key))
-		}
+	codes, err := BuildCanonicalHuffmanEncoder(symbolFreq[:], maxCodeLength)
+	if err != nil {
+		return err
 	}
-	tree := buildTree(symbolFreq)
-	compressed := encode(tree, contentString, compressionHeader)
-	return compressed
-}
-
-func getSymbolEncoding(tree huffmanTree, symbolEnc map[rune]string, currentPrefix []byte) {
-	switch node := tree.(type) {
-	case huffmanLeaf:
-		symbolEnc[node.symbol] = string(currentPrefix)
-		// b := bitString(string(currentPrefix))
-		// fmt.Printf("[ getSymbolEncoding ] symbol: %s, currentPrefix: %s, in bytes: %v\n", string(node.symbol), string(currentPrefix), b.asByteSlice())
-		return
-	case huffmanNode:
-		getSymbolEncoding(node.left, symbolEnc, append(currentPrefix, byte('0')))
-		getSymbolEncoding(node.right, symbolEnc, append(currentPrefix, byte('1')))
-		return
+	lengths := codeLengths(codes)
+	header := writeHeader(modeStatic, len(content))
+	if len(content) > 0 {
+		header = append(header, packLengths(lengths)...)
 	}
+	if _, err := output.Write(header); err != nil {
+		return err
+	}
+	return encode(codes, content, output)
 }
 
-func (b bitString) asByteSlice() []byte {
-	var output []byte
-	for i := len(b); i > 0; i -= 8 {
-		var chunk string
-		if i < 8 {
-			chunk = string(b[:i])
-		} else {
-			chunk = string(b[i-8 : i])
-		}
-		chunkInt, err := strconv.ParseUint(chunk, 2, 8)
-		if err != nil {
-			fmt.Println("Error converting string to byte for compression")
-			os.Exit(1)
+// encode writes the codes bit reversed into an LSB-first stream, as DEFLATE
+// does, padded with zeros to a whole byte. The decoder knows the original
+// length, so the padding needs no marker.
+func encode(codes []CanonicalHuffman, input []byte, output io.Writer) error {
+	var symbolCode [alphabetSize]uint32
+	var symbolLength [alphabetSize]uint
+	for symbol, code := range codes {
+		if code != nil && code.GetLength() > 0 {
+			symbolCode[symbol] = Reverse(uint32(code.GetValue()), uint32(code.GetLength()))
+			symbolLength[symbol] = uint(code.GetLength())
 		}
-		output = append(output, byte(chunkInt))
 	}
-	slices.Reverse(output)
-	return output
-}
-
-func encode(tree huffmanTree, input string, compressionHeader strings.Builder) []byte {
-	var output strings.Builder
-	symbolEnc := make(map[rune]string)
-	getSymbolEncoding(tree, symbolEnc, []byte{})
+	bw := NewBitWriter(output)
 	for _, symbol := range input {
-		encoding, ok := symbolEnc[symbol]
-		if !ok {
-			fmt.Println("Symbol does not exist in huffman tree.")
-			os.Exit(1)
+		if err := bw.WriteBits(symbolCode[symbol], symbolLength[symbol]); err != nil {
+			return err
 		}
-		fmt.Fprintf(&output, "%s", encoding)
 	}
-	paddingBits := bitString(strconv.FormatInt(int64((8-len(output.String())%8)%8), 2))
-	paddingByte := paddingBits.asByteSlice()
-	// fmt.Printf("[ encode ] output: %v\n", output.String())
-	inputBitString := bitString(output.String())
-	inputBytes := inputBitString.asByteSlice()
-	// fmt.Printf("[ encode ] compressionHeader:%s\n\nlen(output.String()):%v\n\npaddingBits:%v\n\npaddingbyte:\n%v\n\ninputbytes:\n%v\n\n\n", compressionHeader.String(), len(output.String()), paddingBits, paddingByte, inputBytes)
-	out := append([]byte(compressionHeader.String()), append([]byte("\\\n"), append(paddingByte, inputBytes...)...)...)
-	// fmt.Printf("[ encode ] final out: %v\n", out)
-	return out
+	return bw.Flush()
 }
@@ -0,0 +1,104 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// compressContext keeps an order-1 model: the code for each byte comes from
+// the table of the byte before it, the first byte counting as following a
+// zero. After the length comes a 256-bit map of the contexts that occur and
+// then the length table of each of them in order.
+func compressContext(content []byte, output io.Writer) error {
+	var contextFreq [alphabetSize][alphabetSize]int
+	prev := 0
+	for _, b := range content {
+		contextFreq[prev][b]++
+		prev = int(b)
+	}
+	header := writeHeader(modeContext, len(content))
+	if len(content) == 0 {
+		_, err := output.Write(header)
+		return err
+	}
+	var symbolCode [alphabetSize][alphabetSize]uint32
+	var symbolLength [alphabetSize][alphabetSize]uint
+	contexts := make([]byte, alphabetSize/8)
+	var tables []byte
+	for context := range alphabetSize {
+		codes, err := BuildCanonicalHuffmanEncoder(contextFreq[context][:], maxCodeLength)
+		if err != nil {
+			return err
+		}
+		var lengths [alphabetSize]int
+		isSeen := false
+		for symbol, code := range codes {
+			if code != nil && code.GetLength() > 0 {
+				lengths[symbol] = code.GetLength()
+				symbolCode[context][symbol] = Reverse(uint32(code.GetValue()), uint32(code.GetLength()))
+				symbolLength[context][symbol] = uint(code.GetLength())
+				isSeen = true
+			}
+		}
+		if isSeen {
+			contexts[context/8] |= 1 << (7 - context%8)
+			tables = append(tables, packLengths(lengths)...)
+		}
+	}
+	header = append(append(header, contexts...), tables...)
+	if _, err := output.Write(header); err != nil {
+		return err
+	}
+	bw := NewBitWriter(output)
+	prev = 0
+	for _, b := range content {
+		if err := bw.WriteBits(symbolCode[prev][b], symbolLength[prev][b]); err != nil {
+			return err
+		}
+		prev = int(b)
+	}
+	return bw.Flush()
+}
+
+func decompressContext(content []byte, length int) ([]byte, error) {
+	if len(content) < alphabetSize/8 {
+		return nil, errors.New("huffman context map is truncated")
+	}
+	contexts := content[:alphabetSize/8]
+	content = content[alphabetSize/8:]
+	var decoders [alphabetSize]*TableDecoder
+	for context := range alphabetSize {
+		if contexts[context/8]&(1<<(7-context%8)) == 0 {
+			continue
+		}
+		lengths, rest, err := unpackLengths(content)
+		if err != nil {
+			return nil, fmt.Errorf("table of context %v: %w", context, err)
+		}
+		if decoders[context], err = BuildTableDecoder(lengths[:]); err != nil {
+			return nil, fmt.Errorf("table of context %v: %w", context, err)
+		}
+		content = rest
+	}
+	// every symbol takes at least one bit
+	if length > len(content)*8 {
+		return nil, fmt.Errorf("compressed data is too short for %v bytes", length)
+	}
+	br := NewBitReader(bytes.NewReader(content))
+	output := make([]byte, length)
+	prev := 0
+	for i := range output {
+		if decoders[prev] == nil {
+			return nil, fmt.Errorf("byte %v of %v: no table for context %v", i, length, prev)
+		}
+		if symbol, err := decoders[prev].Decode(br); err != nil {
+			return nil, fmt.Errorf("byte %v of %v: %w", i, length, err)
+		} else {
+			output[i] = byte(symbol)
+			prev = symbol
+		}
+	}
+	return output, nil
+}
@@ -5,10 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"strconv"
-	"strings"
 	"sync"
-	"unicode"
 )
 
 type DecompressionWriter struct {
@@ -20,6 +17,8 @@ type DecompressionReader struct {
 
 type decompressionCore struct {
 	isInputBufferClosed bool
+	err                 error
+	cond                *sync.Cond
 	lock                sync.Mutex
 	inputBuffer         io.ReadWriter
 	outputBuffer        io.ReadWriter
@@ -28,8 +27,11 @@ type decompressionCore struct {
 func (dr *DecompressionReader) Read(data []byte) (int, error) {
 	dr.core.lock.Lock()
 	defer dr.core.lock.Unlock()
-	if !dr.core.isInputBufferClosed {
-		return 0, errors.New("input buffer not closed")
+	for !dr.core.isInputBufferClosed {
+		dr.core.cond.Wait()
+	}
+	if dr.core.err != nil {
+		return 0, dr.core.err
 	}
 	return dr.core.outputBuffer.Read(data)
 }
@@ -55,14 +57,20 @@ func (dw *DecompressionWriter) Write(data []byte) (int, error) {
 func (dw *DecompressionWriter) Close() error {
 	dw.core.lock.Lock()
 	defer dw.core.lock.Unlock()
+	// the reader waits until the whole input has been processed
+	defer dw.core.cond.Broadcast()
 	dw.core.isInputBufferClosed = true
 	compressedData, err := io.ReadAll(dw.core.inputBuffer)
 	// fmt.Printf("[ DecompressionWriter.Close ] compressedData: %v\n", compressedData)
 	if err != nil {
+		dw.core.err = err
 		return err
 	}
-	decompressedData := decompress(compressedData)
-	if _, err = dw.core.outputBuffer.Write(decompressedData); err != nil {
+	if decompressedData, err := decompress(compressedData); err != nil {
+		dw.core.err = err
+		return err
+	} else if _, err = dw.core.outputBuffer.Write(decompressedData); err != nil {
+		dw.core.err = err
 		return err
 	}
 	return nil
@@ -72,104 +80,64 @@ func NewDecompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
 	newDecompressionCore := new(decompressionCore)
 	newDecompressionCore.inputBuffer, newDecompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
 	newDecompressionCore.isInputBufferClosed = false
+	newDecompressionCore.cond = sync.NewCond(&newDecompressionCore.lock)
 	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
 	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
 	return newDecompressionReader, newDecompressionWriter
 }
 
-func decompress(content []byte) []byte {
-	contentString := string(content)
-	compressionHeader := strings.SplitN(contentString, "\\\n", 2)[0]
-	// fmt.Printf("[ decompress ] compressionHeader: %v\n", compressionHeader)
-	headerRunes := []rune(compressionHeader)
-	symbolFreq := make(map[rune]int)
-	for i := range len(headerRunes) {
-		if headerRunes[i] == '|' && headerRunes[i-1] != '|' {
-			endFreq := i
-			startFreq := endFreq - 1
-			for startFreq > 0 && unicode.IsDigit(headerRunes[startFreq-1]) && (startFreq == 1 || headerRunes[startFreq-2] != rune('|')) {
-				startFreq--
-			}
-			freq, err := strconv.Atoi(string(headerRunes[startFreq:endFreq]))
-			if err != nil {
-				panic(err)
-			}
-			if headerRunes[i+1] != rune('\\') || i+2 >= len(headerRunes) || headerRunes[i+2] != 'n' {
-				symbolFreq[headerRunes[i+1]] = freq
-			} else {
-				symbolFreq[10] = freq
-			}
-		}
+func decompress(content []byte) ([]byte, error) {
+	if len(content) > 0 && content[0] == modeAdaptive {
+		return io.ReadAll(newAdaptiveReader(NewBitReader(bytes.NewReader(content))))
 	}
-	tree := buildTree(symbolFreq)
-	decompressedData := decode(tree, contentString)
-	return decompressedData
-}
-
-func getSymbolDecoded(root huffmanTree, huffmanCode string) *strings.Builder {
-	var data strings.Builder
-	switch node := root.(type) {
-	case huffmanLeaf:
-		fmt.Fprintf(&data, "%s", string(node.symbol))
-		return &data
-	case huffmanNode:
-		for index := 0; index < len(huffmanCode); index++ {
-			if huffmanCode[index] == '0' {
-				var err error
-				if index, err = getSymbol(node.left, huffmanCode, index, &data); err != nil {
-					panic(err)
-				}
-			} else {
-				var err error
-				if index, err = getSymbol(node.right, huffmanCode, index, &data); err != nil {
-					panic(err)
-				}
-			}
+	mode, length, content, err := readHeader(content)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	switch mode {
+	case modeStatic:
+		lengths, payload, err := unpackLengths(content)
+		if err != nil {
+			return nil, err
+		}
+		decoder, err := BuildTableDecoder(lengths[:])
+		if err != nil {
+			return nil, err
 		}
+		return decode(decoder, payload, length)
+	case modeContext:
+		return decompressContext(content, length)
+	case modeChunked:
+		return decompressChunked(content, length)
 	}
-	return &data
+	return nil, fmt.Errorf("huffman mode %v is not supported", mode)
 }
 
-func getSymbol(currentNode huffmanTree, huffmanCode string, index int, data *strings.Builder) (int, error) {
-	switch node := currentNode.(type) {
-	case huffmanLeaf:
-		// fmt.Printf("[ getSymbol ] node.symbol %v\n", string(node.symbol))
-		fmt.Fprintf(data, "%s", string(node.symbol))
-		return index, nil
-	case huffmanNode:
-		index++
-		if index >= len(huffmanCode) {
-			return index, errors.New("[ getSymbol ] out of index error")
-		}
-		if huffmanCode[index] == '0' {
-			return getSymbol(node.left, huffmanCode, index, data)
-		} else {
-			return getSymbol(node.right, huffmanCode, index, data)
-		}
-	default:
-		return -1, errors.New("[ getSymbol ] type unknown")
+func decode(decoder *TableDecoder, payload []byte, length int) ([]byte, error) {
+	// every symbol takes at least one bit, a longer length is not allocated
+	if length > len(payload)*8 {
+		return nil, fmt.Errorf("compressed data is too short for %v bytes", length)
+	}
+	output := make([]byte, length)
+	if err := decodeInto(decoder, payload, output); err != nil {
+		return nil, err
 	}
+	return output, nil
 }
 
-func decode(tree huffmanTree, input string) []byte {
-	contentString := strings.SplitN(input, "\\\n", 2)[1]
-	contentBytes := []byte(contentString)
-	// fmt.Printf("[ decode ] contentString: %v\n", contentBytes)
-	var huffmanCodeBuilder strings.Builder
-	var offset int
-	for i, bait := range contentBytes {
-		if i > 0 {
-			binary := fmt.Sprintf("%08b", bait)
-			// fmt.Printf("[ decode ] bait: %v --- binary: %v\n", bait, binary)
-			fmt.Fprintf(&huffmanCodeBuilder, "%s", binary)
+// decodeInto fills output with symbols decoded from payload. Callers check
+// the length against the payload before they allocate output.
+func decodeInto(decoder *TableDecoder, payload []byte, output []byte) error {
+	br := NewBitReader(bytes.NewReader(payload))
+	for i := range output {
+		if symbol, err := decoder.Decode(br); err != nil {
+			return fmt.Errorf("byte %v of %v: %w", i, len(output), err)
 		} else {
-			offset = int(bait)
+			output[i] = byte(symbol)
 		}
 	}
-	// fmt.Printf("[ decode ] offset: %v\n", offset)
-	huffmanCode := huffmanCodeBuilder.String()[offset:]
-	// fmt.Printf("[ decode ] huffmanCode: %v\n", huffmanCode)
-	var decompressedData *strings.Builder = getSymbolDecoded(tree, huffmanCode)
-	// fmt.Printf("[ decode ] decompressedData: %v\n", decompressedData.String())
-	return []byte(decompressedData.String())
+	return nil
 }
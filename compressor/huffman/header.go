@@ -0,0 +1,118 @@
+package huffman
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	alphabetSize  = 256
+	maxCodeLength = 15
+
+	modeStatic   byte = 0
+	modeAdaptive byte = 1
+	modeContext  byte = 2
+	modeChunked  byte = 3
+)
+
+// writeHeader starts every .shk file but an adaptive one: the mode byte,
+// then the original length as a uvarint. The code length tables follow for
+// non-empty input.
+func writeHeader(mode byte, length int) []byte {
+	return binary.AppendUvarint([]byte{mode}, uint64(length))
+}
+
+// packLengths stores a code length table as a 256-bit map of the symbols
+// present followed by their lengths, two to a byte, high nibble first.
+func packLengths(lengths [alphabetSize]int) []byte {
+	presence := make([]byte, alphabetSize/8)
+	var nibbles []byte
+	for symbol, length := range lengths {
+		if length > 0 {
+			presence[symbol/8] |= 1 << (7 - symbol%8)
+			nibbles = append(nibbles, byte(length))
+		}
+	}
+	output := presence
+	for i := 0; i < len(nibbles); i += 2 {
+		b := nibbles[i] << 4
+		if i+1 < len(nibbles) {
+			b |= nibbles[i+1]
+		}
+		output = append(output, b)
+	}
+	return output
+}
+
+// readHeader returns the mode, the original length and whatever follows them.
+func readHeader(content []byte) (byte, int, []byte, error) {
+	if len(content) == 0 {
+		return 0, 0, nil, errors.New("huffman header is missing")
+	}
+	mode := content[0]
+	length, n := binary.Uvarint(content[1:])
+	if n <= 0 || length > math.MaxInt {
+		return 0, 0, nil, errors.New("huffman header has an invalid original length")
+	}
+	content = content[1+n:]
+	if length == 0 && len(content) > 0 {
+		return 0, 0, nil, errors.New("huffman header says the input was empty but data follows it")
+	}
+	return mode, int(length), content, nil
+}
+
+func unpackLengths(content []byte) ([alphabetSize]uint32, []byte, error) {
+	var lengths [alphabetSize]uint32
+	if len(content) < alphabetSize/8 {
+		return lengths, nil, errors.New("huffman symbol map is truncated")
+	}
+	presence := content[:alphabetSize/8]
+	content = content[alphabetSize/8:]
+	count := 0
+	for symbol := range alphabetSize {
+		if presence[symbol/8]&(1<<(7-symbol%8)) == 0 {
+			continue
+		}
+		if count/2 >= len(content) {
+			return lengths, nil, errors.New("huffman code lengths are truncated")
+		}
+		b := content[count/2]
+		if count%2 == 0 {
+			b >>= 4
+		}
+		lengths[symbol] = uint32(b & 0x0f)
+		count++
+	}
+	if err := checkLengths(lengths[:]); err != nil {
+		return lengths, nil, err
+	}
+	return lengths, content[(count+1)/2:], nil
+}
+
+// checkLengths makes sure the lengths describe a complete prefix code, or a
+// lone symbol with a one-bit code, before a decoder is built from them.
+func checkLengths(lengths []uint32) error {
+	count, kraft := 0, 0
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		if length > maxCodeLength {
+			return fmt.Errorf("code length %v of symbol %v is over the limit %v", length, symbol, maxCodeLength)
+		}
+		count++
+		kraft += 1 << (maxCodeLength - length)
+	}
+	if count == 0 {
+		return errors.New("huffman header has no symbols")
+	}
+	if count == 1 && kraft == 1<<(maxCodeLength-1) {
+		return nil
+	}
+	if kraft != 1<<maxCodeLength {
+		return errors.New("huffman code lengths do not form a complete prefix code")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package huffman
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	primaryTableBits = 9
+	entryLengthMask  = 0x1F
+	entrySubTable    = 0x20
+	entryValueShift  = 8
+)
+
+// TableDecoder decodes canonical Huffman codes with a primary lookup table
+// indexed by the next primaryTableBits bits of input. Codes longer than that
+// share a primary entry that points to a second-level table, the way zlib's
+// inflate_fast does. Each entry packs the symbol (or the sub-table offset)
+// above the code length (or the sub-table index width).
+type TableDecoder struct {
+	primary []uint32
+	sub     []uint32
+}
+
+func BuildTableDecoder(lengths []uint32) (*TableDecoder, error) {
+	maxLength := uint32(0)
+	for _, length := range lengths {
+		maxLength = max(maxLength, length)
+	}
+	if maxLength > 31 {
+		return nil, fmt.Errorf("code length %v is too long for the table decoder", maxLength)
+	}
+	lengthCounts := make([]int, maxLength+1)
+	for _, length := range lengths {
+		if length > 0 {
+			lengthCounts[length]++
+		}
+	}
+	// over-subscribed code lengths cannot form a prefix code
+	left := 1
+	for i := 1; i <= int(maxLength); i++ {
+		left = left<<1 - lengthCounts[i]
+		if left < 0 {
+			return nil, errors.New("code lengths are over-subscribed")
+		}
+	}
+	nextBaseCode := make([]uint32, maxLength+1)
+	code := uint32(0)
+	for i := 1; i <= int(maxLength); i++ {
+		code = (code + uint32(lengthCounts[i-1])) << 1
+		nextBaseCode[i] = code
+	}
+	lengthCounts[0] = 0
+
+	td := &TableDecoder{
+		primary: make([]uint32, 1<<primaryTableBits),
+	}
+	// width of the sub-table behind every primary index that long codes share
+	subBits := make(map[uint32]uint32)
+	reversed := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		reversed[symbol] = Reverse(nextBaseCode[length], length)
+		nextBaseCode[length]++
+		if length > primaryTableBits {
+			prefix := reversed[symbol] & (1<<primaryTableBits - 1)
+			subBits[prefix] = max(subBits[prefix], length-primaryTableBits)
+		}
+	}
+	for prefix := range uint32(1 << primaryTableBits) {
+		if bits, ok := subBits[prefix]; ok {
+			td.primary[prefix] = uint32(len(td.sub))<<entryValueShift | entrySubTable | bits
+			td.sub = append(td.sub, make([]uint32, 1<<bits)...)
+		}
+	}
+	for symbol, length := range lengths {
+		if length == 0 {
+			continue
+		}
+		rev := reversed[symbol]
+		if length <= primaryTableBits {
+			for i := rev; i < 1<<primaryTableBits; i += 1 << length {
+				td.primary[i] = uint32(symbol)<<entryValueShift | length
+			}
+			continue
+		}
+		link := td.primary[rev&(1<<primaryTableBits-1)]
+		offset, bits := link>>entryValueShift, link&entryLengthMask
+		subLength := length - primaryTableBits
+		for i := rev >> primaryTableBits; i < 1<<bits; i += 1 << subLength {
+			td.sub[offset+i] = uint32(symbol)<<entryValueShift | length
+		}
+	}
+	return td, nil
+}
+
+// Decode only refills from what is already buffered. When the bits held are
+// too few to settle a code it waits for one more byte at a time, so the last
+// codes before a sync flush decode without any input after them.
+func (td *TableDecoder) Decode(br *BitReader) (int, error) {
+	if br.bitsCount < 32 {
+		br.refillBuffered()
+	}
+	for {
+		entry := td.primary[br.bitsHold&(1<<primaryTableBits-1)]
+		if entry&entrySubTable != 0 {
+			offset, bits := entry>>entryValueShift, entry&entryLengthMask
+			entry = td.sub[offset+uint32(br.bitsHold>>primaryTableBits)&(1<<bits-1)]
+		}
+		length := uint(entry & entryLengthMask)
+		if length != 0 && length <= br.bitsCount {
+			br.bitsHold >>= length
+			br.bitsCount -= length
+			return int(entry >> entryValueShift), nil
+		}
+		// the bits missing from the holder read as zeros, so the entry can only
+		// be trusted once it is covered or no code is that long
+		if br.bitsCount < 32 && br.err == nil && br.readByte() {
+			continue
+		}
+		if length == 0 && br.bitsCount > 0 {
+			return 0, errors.New("invalid huffman code in the compressed data")
+		}
+		return 0, br.eofError()
+	}
+}
@@ -7,8 +7,6 @@ import (
 	"sort"
 )
 
-type bitString string
-
 type CanonicalHuffmanCode struct {
 	Code   int
 	Length int
@@ -127,6 +125,9 @@ func BuildCanonicalHuffmanEncoder(symbolFreq []int, lengthLimit int) ([]Canonica
 		}
 	}
 	lengths := make([]int, len(symbolFreq))
+	if len(symbolFreqMap) == 0 {
+		return make([]CanonicalHuffman, len(symbolFreq)), nil
+	}
 	root := buildTree(symbolFreqMap)
 	var dfs func(huffmanTree, int)
 	dfs = func(tree huffmanTree, len int) {
@@ -150,7 +151,73 @@ func BuildCanonicalHuffmanEncoder(symbolFreq []int, lengthLimit int) ([]Canonica
 		maxLength = max(maxLength, length)
 	}
 	if maxLength > lengthLimit {
-		return nil, fmt.Errorf("tree is longer than the limit %v\n", lengthLimit)
+		var err error
+		if lengths, err = packageMerge(symbolFreq, lengthLimit); err != nil {
+			return nil, err
+		}
+	}
+	return AssignCanonicalHuffmanCodes(lengths), nil
+}
+
+// packageMerge finds the optimal code lengths that do not exceed lengthLimit
+// by treating every symbol as a coin worth its frequency and buying a total
+// denomination of n-1 with the cheapest coins across lengthLimit levels.
+func packageMerge(symbolFreq []int, lengthLimit int) ([]int, error) {
+	type coin struct {
+		weight  int
+		symbols []int
+	}
+	var leaves []coin
+	for symbol, freq := range symbolFreq {
+		if freq > 0 {
+			leaves = append(leaves, coin{weight: freq, symbols: []int{symbol}})
+		}
+	}
+	lengths := make([]int, len(symbolFreq))
+	if len(leaves) == 1 {
+		lengths[leaves[0].symbols[0]] = 1
+		return lengths, nil
+	}
+	if lengthLimit >= 31 || len(leaves) > 1<<lengthLimit {
+		return nil, fmt.Errorf("%v symbols cannot be coded within the limit %v\n", len(leaves), lengthLimit)
+	}
+	sort.SliceStable(leaves, func(i, j int) bool {
+		return leaves[i].weight < leaves[j].weight
+	})
+	merged := leaves
+	for range lengthLimit - 1 {
+		var packages []coin
+		for i := 0; i+1 < len(merged); i += 2 {
+			packages = append(packages, coin{
+				weight:  merged[i].weight + merged[i+1].weight,
+				symbols: append(append([]int{}, merged[i].symbols...), merged[i+1].symbols...),
+			})
+		}
+		next := make([]coin, 0, len(leaves)+len(packages))
+		i, j := 0, 0
+		for i < len(leaves) || j < len(packages) {
+			if j == len(packages) || (i < len(leaves) && leaves[i].weight <= packages[j].weight) {
+				next = append(next, leaves[i])
+				i++
+			} else {
+				next = append(next, packages[j])
+				j++
+			}
+		}
+		merged = next
+	}
+	for _, c := range merged[:2*len(leaves)-2] {
+		for _, symbol := range c.symbols {
+			lengths[symbol]++
+		}
+	}
+	return lengths, nil
+}
+
+func AssignCanonicalHuffmanCodes(lengths []int) []CanonicalHuffman {
+	maxLength := 0
+	for _, length := range lengths {
+		maxLength = max(maxLength, length)
 	}
 	lengthCounts := make([]int, maxLength+1)
 	var order []struct{ symbol, length int }
@@ -178,7 +245,7 @@ func BuildCanonicalHuffmanEncoder(symbolFreq []int, lengthLimit int) ([]Canonica
 		nextBaseCode[i] = code
 		// fmt.Printf("[ BuildCanonicalHuffmanTree ] length: %v, count: %v, nextBaseCode: %v\n", i, lengthCounts[i], nextBaseCode[i])
 	}
-	output := make([]CanonicalHuffman, len(symbolFreq))
+	output := make([]CanonicalHuffman, len(lengths))
 	for _, info := range order {
 		output[info.symbol] = CanonicalHuffmanCode{
 			Code:   nextBaseCode[info.length],
@@ -187,7 +254,7 @@ func BuildCanonicalHuffmanEncoder(symbolFreq []int, lengthLimit int) ([]Canonica
 		// checkMSBLength(output[info.symbol])
 		nextBaseCode[info.length]++
 	}
-	return output, nil
+	return output
 }
 
 func BuildCanonicalHuffmanDecoder(lengths []uint32) (*CanonicalHuffmanNode, error) {
@@ -13,6 +13,8 @@ import (
 
 type compressionCore struct {
 	isInputBufferClosed bool
+	err                 error
+	cond                *sync.Cond
 	lock                sync.Mutex
 	inputBuffer         io.ReadWriter
 	outputBuffer        io.ReadWriter
@@ -37,13 +39,17 @@ func (cw *CompressionWriter) Write(data []byte) (int, error) {
 func (cw *CompressionWriter) Close() error {
 	cw.core.lock.Lock()
 	defer cw.core.lock.Unlock()
+	// the reader waits until the whole input has been processed
+	defer cw.core.cond.Broadcast()
 	cw.core.isInputBufferClosed = true
 	originalData, err := io.ReadAll(cw.core.inputBuffer)
 	if err != nil {
+		cw.core.err = err
 		return err
 	}
 	compressedData := compress(originalData, cw.core.maxMatchDistance, cw.core.maxMatchLength)
 	if _, err = cw.core.outputBuffer.Write(compressedData); err != nil {
+		cw.core.err = err
 		return err
 	}
 	return nil
@@ -52,8 +58,11 @@ func (cw *CompressionWriter) Close() error {
 func (cr *CompressionReader) Read(data []byte) (int, error) {
 	cr.core.lock.Lock()
 	defer cr.core.lock.Unlock()
-	if !cr.core.isInputBufferClosed {
-		return 0, errors.New("compression failed because compression content upload has not been signaled as complete!")
+	for !cr.core.isInputBufferClosed {
+		cr.core.cond.Wait()
+	}
+	if cr.core.err != nil {
+		return 0, cr.core.err
 	}
 	return cr.core.outputBuffer.Read(data)
 }
@@ -73,6 +82,7 @@ func NewCompressionReaderAndWriter(matchDistance, matchLength int) (io.ReadClose
 	newCompressionCore := new(compressionCore)
 	newCompressionCore.inputBuffer, newCompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
 	newCompressionCore.isInputBufferClosed = false
+	newCompressionCore.cond = sync.NewCond(&newCompressionCore.lock)
 	newCompressionCore.maxMatchDistance = matchDistance
 	newCompressionCore.maxMatchLength = min(matchLength, matchDistance)
 	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
@@ -3,6 +3,7 @@ package lzss
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"slices"
 	"strconv"
@@ -11,6 +12,8 @@ import (
 
 type decompressionCore struct {
 	isInputBufferClosed bool
+	err                 error
+	cond                *sync.Cond
 	lock                sync.Mutex
 	inputBuffer         io.ReadWriter
 	outputBuffer        io.ReadWriter
@@ -33,16 +36,21 @@ func (dw *DecompressionWriter) Write(data []byte) (int, error) {
 func (dw *DecompressionWriter) Close() error {
 	dw.core.lock.Lock()
 	defer dw.core.lock.Unlock()
+	// the reader waits until the whole input has been processed
+	defer dw.core.cond.Broadcast()
 	dw.core.isInputBufferClosed = true
 	compressedData, err := io.ReadAll(dw.core.inputBuffer)
 	if err != nil {
+		dw.core.err = err
 		return err
 	}
 	decompressedData, err := decompress(compressedData)
 	if err != nil {
+		dw.core.err = err
 		return err
 	}
 	if _, err = dw.core.outputBuffer.Write(decompressedData); err != nil {
+		dw.core.err = err
 		return err
 	}
 	return nil
@@ -51,8 +59,11 @@ func (dw *DecompressionWriter) Close() error {
 func (dr *DecompressionReader) Read(data []byte) (int, error) {
 	dr.core.lock.Lock()
 	defer dr.core.lock.Unlock()
-	if !dr.core.isInputBufferClosed {
-		return 0, errors.New("decompression failed because compression content upload has not been signaled as complete!")
+	for !dr.core.isInputBufferClosed {
+		dr.core.cond.Wait()
+	}
+	if dr.core.err != nil {
+		return 0, dr.core.err
 	}
 	return dr.core.outputBuffer.Read(data)
 }
@@ -72,6 +83,7 @@ func NewDecompressionReaderAndWriter() (io.ReadCloser, io.WriteCloser) {
 	newDecompressionCore := new(decompressionCore)
 	newDecompressionCore.inputBuffer, newDecompressionCore.outputBuffer = new(bytes.Buffer), new(bytes.Buffer)
 	newDecompressionCore.isInputBufferClosed = false
+	newDecompressionCore.cond = sync.NewCond(&newDecompressionCore.lock)
 	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
 	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
 	return newDecompressionReader, newDecompressionWriter
@@ -115,7 +127,11 @@ func decodeBackRefs(refedContent []rune) ([]rune, error) {
 					return nil, err
 				}
 				refOn = false
-				derefedContent = append(derefedContent, replaceRef(derefedContent, currentRefStart, currentNegOffset, currentLength)...)
+				if ref, err := replaceRef(derefedContent, currentRefStart, currentNegOffset, currentLength); err != nil {
+					return nil, err
+				} else {
+					derefedContent = append(derefedContent, ref...)
+				}
 			default:
 				refValue = append(refValue, refedContent[i])
 			}
@@ -123,6 +139,9 @@ func decodeBackRefs(refedContent []rune) ([]rune, error) {
 			derefedContent = append(derefedContent, refedContent[i])
 		}
 	}
+	if refOn {
+		return nil, errors.New("compressed input ends inside a back reference")
+	}
 	return derefedContent, nil
 }
 
@@ -140,10 +159,13 @@ func countEscapesInReverse(content []rune, endIdx int) int {
 	return count
 }
 
-func replaceRef(content []rune, refIdx, negOffset, length int) []rune {
+func replaceRef(content []rune, refIdx, negOffset, length int) ([]rune, error) {
 	startIdx := refIdx - negOffset
 	endIdx := startIdx + length
-	return content[startIdx:endIdx]
+	if startIdx < 0 || length < 0 || endIdx > len(content) {
+		return nil, fmt.Errorf("back reference <%v,%v> points outside the decoded content", negOffset, length)
+	}
+	return content[startIdx:endIdx], nil
 }
 
 func removeEscapes(content []rune) ([]rune, error) {
@@ -0,0 +1,135 @@
+package zlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/adler32"
+	"io"
+	"sync"
+)
+
+type CompressionCore struct {
+	lock        sync.Mutex
+	Writer      *io.PipeWriter
+	Reader      *io.PipeReader
+	FlateWriter io.WriteCloser
+	FlateReader io.ReadCloser
+	Adler       hash.Hash32
+	Header      []byte
+	err         error
+	done        chan error
+}
+
+type CompressionReader struct {
+	core *CompressionCore
+}
+
+type CompressionWriter struct {
+	core *CompressionCore
+}
+
+func NewCompressionReaderAndWriter(flateReader io.ReadCloser, flateWriter io.WriteCloser, level int, dictionary []byte) (io.ReadCloser, io.WriteCloser) {
+	newCompressionCore := new(CompressionCore)
+	newCompressionCore.Reader, newCompressionCore.Writer = io.Pipe()
+	newCompressionCore.FlateReader, newCompressionCore.FlateWriter = flateReader, flateWriter
+	newCompressionCore.Adler = adler32.New()
+	newCompressionCore.done = make(chan error, 1)
+	newCompressionReader, newCompressionWriter := new(CompressionReader), new(CompressionWriter)
+	newCompressionReader.core, newCompressionWriter.core = newCompressionCore, newCompressionCore
+
+	cmf := byte(0x78) // CM = deflate, CINFO = 7 (32 KiB window)
+	var flg byte
+	switch {
+	case level < 2:
+		flg = 0 << 6 // fastest algorithm
+	case level < 6:
+		flg = 1 << 6 // fast algorithm
+	case level == 6:
+		flg = 2 << 6 // default algorithm
+	default:
+		flg = 3 << 6 // maximum compression
+	}
+	if dictionary != nil {
+		flg |= 0x20 // FDICT
+	}
+	// FCHECK makes CMF*256 + FLG a multiple of 31
+	flg |= byte((31 - (uint16(cmf)<<8|uint16(flg))%31) % 31)
+	newCompressionCore.Header = []byte{cmf, flg}
+	if dictionary != nil {
+		newCompressionCore.Header = binary.BigEndian.AppendUint32(newCompressionCore.Header, adler32.Checksum(dictionary))
+		if setter, ok := flateWriter.(interface{ SetDictionary([]byte) error }); !ok {
+			newCompressionCore.err = errors.New("underlying flate writer does not support preset dictionaries")
+		} else {
+			newCompressionCore.err = setter.SetDictionary(dictionary)
+		}
+	}
+	go newCompressionCore.pump()
+	return newCompressionReader, newCompressionWriter
+}
+
+// pump forwards the header and then the deflate stream as the flate writer
+// produces it.
+func (core *CompressionCore) pump() {
+	_, err := core.Writer.Write(core.Header)
+	if err == nil {
+		_, err = io.Copy(core.Writer, core.FlateReader)
+	}
+	if err != nil {
+		core.FlateReader.Close()
+	}
+	core.done <- err
+}
+
+func (cw *CompressionWriter) Write(p []byte) (int, error) {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.err != nil {
+		return 0, cw.core.err
+	}
+	cw.core.Adler.Write(p)
+	return cw.core.FlateWriter.Write(p)
+}
+
+func (cw *CompressionWriter) Flush() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if flusher, ok := cw.core.FlateWriter.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return errors.New("underlying flate writer does not support flushing")
+}
+
+func (cw *CompressionWriter) Close() error {
+	cw.core.lock.Lock()
+	defer cw.core.lock.Unlock()
+	if cw.core.err != nil {
+		cw.core.FlateReader.Close()
+		cw.core.Writer.CloseWithError(cw.core.err)
+		return cw.core.err
+	}
+	if err := cw.core.FlateWriter.Close(); err != nil {
+		cw.core.FlateReader.Close()
+		return err
+	}
+	if err := <-cw.core.done; err != nil {
+		return err
+	}
+	if err := cw.core.FlateReader.Close(); err != nil {
+		return err
+	}
+	// the Adler-32 trailer is big-endian, unlike the gzip one
+	trailer := binary.BigEndian.AppendUint32(nil, cw.core.Adler.Sum32())
+	if _, err := cw.core.Writer.Write(trailer); err != nil {
+		return err
+	}
+	return cw.core.Writer.Close()
+}
+
+func (cr *CompressionReader) Read(p []byte) (int, error) {
+	return cr.core.Reader.Read(p)
+}
+
+func (cr *CompressionReader) Close() error {
+	return cr.core.Reader.Close()
+}
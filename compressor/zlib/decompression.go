@@ -0,0 +1,140 @@
+package zlib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"io"
+
+	"github.com/FitrahHaque/Compression-Engine/compressor/flate"
+	"github.com/FitrahHaque/Compression-Engine/compressor/huffman"
+)
+
+type DecompressionCore struct {
+	Writer      *io.PipeWriter
+	Reader      *io.PipeReader
+	InputWriter *io.PipeWriter
+	InputReader *io.PipeReader
+	Dictionary  []byte
+	Trailer     []byte
+	CurrentSum  hash.Hash32
+}
+
+type DecompressionWriter struct {
+	core *DecompressionCore
+}
+
+type DecompressionReader struct {
+	core *DecompressionCore
+}
+
+func NewDecompressionReaderAndWriter(dictionary []byte) (io.ReadCloser, io.WriteCloser) {
+	newDecompressionCore := new(DecompressionCore)
+	newDecompressionCore.Reader, newDecompressionCore.Writer = io.Pipe()
+	newDecompressionCore.InputReader, newDecompressionCore.InputWriter = io.Pipe()
+	newDecompressionCore.Dictionary = dictionary
+	newDecompressionCore.CurrentSum = adler32.New()
+	newDecompressionCore.Trailer = make([]byte, 4)
+	newDecompressionReader, newDecompressionWriter := new(DecompressionReader), new(DecompressionWriter)
+	newDecompressionReader.core, newDecompressionWriter.core = newDecompressionCore, newDecompressionCore
+	go newDecompressionCore.pump()
+	return newDecompressionReader, newDecompressionWriter
+}
+
+func (core *DecompressionCore) pump() {
+	err := core.decompress()
+	if err != nil {
+		core.InputReader.CloseWithError(err)
+	} else {
+		// anything after the trailer is ignored
+		io.Copy(io.Discard, core.InputReader)
+	}
+	core.Writer.CloseWithError(err)
+}
+
+// Header holds the fields of the two byte zlib header and the optional
+// preset dictionary id that follows it.
+type Header struct {
+	// Level is FLEVEL, from 0 (fastest) to 3 (maximum compression)
+	Level         byte
+	HasDictionary bool
+	DictionaryID  uint32
+}
+
+func ReadHeader(br *huffman.BitReader) (Header, error) {
+	var header Header
+	cmfFlg := make([]byte, 2)
+	if err := br.ReadAlignedBytes(cmfFlg); err != nil {
+		return header, err
+	}
+	cmf, flg := cmfFlg[0], cmfFlg[1]
+	if cmf&0x0f != 8 {
+		return header, fmt.Errorf("compression method %v is not deflate", cmf&0x0f)
+	}
+	if cmf>>4 > 7 {
+		return header, fmt.Errorf("window size 2^%v is larger than 32 KiB", cmf>>4+8)
+	}
+	if (uint16(cmf)<<8|uint16(flg))%31 != 0 {
+		return header, errors.New("header check FCHECK failed")
+	}
+	header.Level = flg >> 6
+	if flg&0x20 != 0 {
+		dictID := make([]byte, 4)
+		if err := br.ReadAlignedBytes(dictID); err != nil {
+			return header, err
+		}
+		header.HasDictionary = true
+		header.DictionaryID = binary.BigEndian.Uint32(dictID)
+	}
+	return header, nil
+}
+
+func (core *DecompressionCore) decompress() error {
+	br := huffman.NewBitReader(core.InputReader)
+	header, err := ReadHeader(br)
+	if err != nil {
+		return err
+	}
+	inflateReader := flate.NewInflateReader(br)
+	if header.HasDictionary {
+		if core.Dictionary == nil {
+			return fmt.Errorf("stream needs a preset dictionary with id %08x", header.DictionaryID)
+		}
+		if header.DictionaryID != adler32.Checksum(core.Dictionary) {
+			return fmt.Errorf("preset dictionary id %08x does not match the stream's %08x", adler32.Checksum(core.Dictionary), header.DictionaryID)
+		}
+		if err := inflateReader.SetDictionary(core.Dictionary); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(core.Writer, io.TeeReader(inflateReader, core.CurrentSum)); err != nil {
+		return err
+	}
+	br.AlignToByte()
+	if err := br.ReadAlignedBytes(core.Trailer); err != nil {
+		return err
+	}
+	// fmt.Printf("[ zlib.DecompressionCore.decompress ] givenSum: %v, currentSum: %v\n", binary.BigEndian.Uint32(core.Trailer), core.CurrentSum.Sum32())
+	if binary.BigEndian.Uint32(core.Trailer) != core.CurrentSum.Sum32() {
+		return errors.New("adler-32 checksum did not match")
+	}
+	return nil
+}
+
+func (dw *DecompressionWriter) Write(p []byte) (int, error) {
+	return dw.core.InputWriter.Write(p)
+}
+
+func (dw *DecompressionWriter) Close() error {
+	return dw.core.InputWriter.Close()
+}
+
+func (dr *DecompressionReader) Read(p []byte) (int, error) {
+	return dr.core.Reader.Read(p)
+}
+
+func (dr *DecompressionReader) Close() error {
+	return dr.core.Reader.Close()
+}
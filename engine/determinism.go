@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// CheckDeterminism compresses every file runs times with the same options and
+// compares each output with the first, nothing is written to disk.
+func CheckDeterminism(algorithm string, files []string, runs int, args any) error {
+	if runs < 2 {
+		return errors.New("determinism needs at least 2 runs to compare")
+	}
+	failed := 0
+	for _, file := range files {
+		if identical, err := checkFileDeterminism(algorithm, file, runs, args); err != nil {
+			return fmt.Errorf("could not check `%s`: %w", file, err)
+		} else if !identical {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v files did not compress to the same bytes every run", failed, len(files))
+	}
+	return nil
+}
+
+func checkFileDeterminism(algorithm string, filePath string, runs int, args any) (bool, error) {
+	fileContent, err := os.ReadFile(filePath)
+	if err != nil {
+		return false, err
+	}
+	if gzipArgs, ok := args.(GzipArgs); ok {
+		args = withSourceHeader(gzipArgs, filePath)
+	}
+	first, _ := compressContent(algorithm, fileContent, args)
+	for run := 2; run <= runs; run++ {
+		content, _ := compressContent(algorithm, fileContent, args)
+		if !bytes.Equal(first, content) {
+			fmt.Printf("`%s`: run %v differs from run 1 at byte %v (sizes %v and %v)\n", filePath, run, firstDifference(first, content), len(first), len(content))
+			return false, nil
+		}
+	}
+	fmt.Printf("`%s`: %v runs produced identical output, %v bytes, sha256 %x\n", filePath, runs, len(first), sha256.Sum256(first))
+	return true, nil
+}
+
+func firstDifference(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
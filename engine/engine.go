@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 
+	"github.com/FitrahHaque/Compression-Engine/compressor/bgzf"
 	"github.com/FitrahHaque/Compression-Engine/compressor/flate"
 	"github.com/FitrahHaque/Compression-Engine/compressor/gzip"
 	"github.com/FitrahHaque/Compression-Engine/compressor/huffman"
 	"github.com/FitrahHaque/Compression-Engine/compressor/lzss"
+	"github.com/FitrahHaque/Compression-Engine/compressor/zlib"
 )
 
 var Engines = [...]string{
@@ -19,16 +22,58 @@ var Engines = [...]string{
 	"lzss",
 	"flate",
 	"gzip",
+	"zlib",
+	"bgzf",
+}
+
+type HuffmanArgs struct {
+	// Adaptive codes in one pass with a tree updated after every byte
+	Adaptive bool
+	// Context picks the code table by the previous byte
+	Context bool
+	// ChunkSize above zero gives every chunk of that many bytes its own table
+	ChunkSize int
 }
 
 type FlateArgs struct {
-	Bfinal uint32
-	Btype  uint32
+	Btype     uint32
+	Level     int
+	BlockSize int
+	// Threads above one compresses chunks of the input concurrently
+	Threads int
+	// Rsyncable also ends blocks at content-defined boundaries
+	Rsyncable bool
 }
 
 type GzipArgs struct {
-	Bfinal uint32
-	Btype  uint32
+	Btype     uint32
+	Level     int
+	BlockSize int
+	Threads   int
+	Rsyncable bool
+	Header    gzip.Header
+	// SetOS keeps Header.OS as given, so OS 0 (FAT) is not replaced by the host OS
+	SetOS bool
+	// NoName keeps the source file name and modification time out of the header
+	NoName bool
+	// Append adds the output as a new member of an existing gzip file
+	Append bool
+}
+
+type ZlibArgs struct {
+	Btype      uint32
+	Level      int
+	BlockSize  int
+	Dictionary []byte
+}
+
+type BgzfArgs struct {
+	Btype uint32
+	Level int
+	// Offset and Length pick out a range of the decoded data, a negative
+	// Length runs to the end of the file
+	Offset int64
+	Length int64
 }
 
 type compression struct {
@@ -48,6 +93,8 @@ var compressionReaderAndWriters = map[string]any{
 	"lzss":    lzss.NewCompressionReaderAndWriter,
 	"flate":   flate.NewCompressionReaderAndWriter,
 	"gzip":    gzip.NewCompressionReaderAndWriter,
+	"zlib":    zlib.NewCompressionReaderAndWriter,
+	"bgzf":    bgzf.NewCompressionReaderAndWriter,
 }
 
 var decompressionReaderAndWriters = map[string]any{
@@ -55,6 +102,9 @@ var decompressionReaderAndWriters = map[string]any{
 	"lzss":    lzss.NewDecompressionReaderAndWriter,
 	"flate":   flate.NewDecompressionReaderAndWriter,
 	"gzip":    gzip.NewDecompressionReaderAndWriter,
+	"zlib":    zlib.NewDecompressionReaderAndWriter,
+	// a BGZF file is a multi-member gzip file, the whole of it decodes as one
+	"bgzf": gzip.NewDecompressionReaderAndWriter,
 }
 
 func CompressFiles(algorithm string, files []string, fileExtension string, args any) {
@@ -80,6 +130,9 @@ func compressFile(algorithm string, filePath string, outputFileName string, args
 	if err != nil {
 		panic(err)
 	}
+	if gzipArgs, ok := args.(GzipArgs); ok {
+		args = withSourceHeader(gzipArgs, filePath)
+	}
 	fmt.Println("Compressing...")
 	data := compress(algorithm, fileContent, outputFileName, args)
 	if len(outputFileName) > 0 {
@@ -88,7 +141,76 @@ func compressFile(algorithm string, filePath string, outputFileName string, args
 	return data
 }
 
+// withSourceHeader fills the gzip header from the source file the way gzip -N does,
+// keeping anything the caller has already set.
+func withSourceHeader(args GzipArgs, filePath string) GzipArgs {
+	if !args.SetOS {
+		args.Header.OS = gzip.HostOS()
+	}
+	if args.NoName {
+		return args
+	}
+	if len(args.Header.Name) == 0 {
+		args.Header.Name = filepath.Base(filePath)
+	}
+	if args.Header.ModTime.IsZero() {
+		if info, err := os.Stat(filePath); err == nil {
+			args.Header.ModTime = info.ModTime()
+		}
+	}
+	return args
+}
+
+// appendMember adds a gzip member to the end of an existing gzip file, leaving
+// the members already there untouched. A missing file is created.
+func appendMember(fileName string, member []byte) error {
+	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	magic := make([]byte, 2)
+	if n, err := io.ReadFull(file, magic); err != nil && err != io.EOF {
+		return fmt.Errorf("cannot append to `%s`: %w", fileName, err)
+	} else if n > 0 && !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return fmt.Errorf("cannot append to `%s`, it is not a gzip file", fileName)
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	_, err = file.Write(member)
+	return err
+}
+
 func compress(algorithm string, fileContent []byte, outputFileName string, args any) []byte {
+	content, writer := compressContent(algorithm, fileContent, args)
+	if len(outputFileName) > 0 {
+		var err error
+		if gzipArgs, ok := args.(GzipArgs); ok && gzipArgs.Append {
+			err = appendMember(outputFileName, content)
+		} else {
+			err = os.WriteFile(outputFileName, content, 0644)
+		}
+		if err != nil {
+			panic(err)
+		}
+		if indexer, ok := writer.(interface{ Index() bgzf.Index }); ok {
+			if err = writeIndex(outputFileName+".gzi", indexer.Index()); err != nil {
+				panic(err)
+			}
+			fmt.Printf("Block index has been written into the file `%s`\n", outputFileName+".gzi")
+		}
+	}
+	fmt.Printf("Original size (in bytes): %v\n", len(fileContent))
+	fmt.Printf("Compressed size (in bytes): %v\n", len(content))
+	fmt.Printf("Compression ratio: %.2f%%\n", float32(len(content))/float32(len(fileContent))*100)
+	return content
+}
+
+// compressContent runs fileContent through a fresh compression writer and
+// returns the output along with the writer, which some engines still have
+// more to ask of.
+func compressContent(algorithm string, fileContent []byte, args any) ([]byte, io.WriteCloser) {
 	compressor := compression{
 		compressionEngine: algorithm,
 	}
@@ -117,15 +239,19 @@ func compress(algorithm string, fileContent []byte, outputFileName string, args
 	}
 	// fmt.Printf("[ engine.compress ] 6\n")
 	// fmt.Printf("[ engine.compress ] compressed content(in bytes):\n%v\n", content)
-	if len(outputFileName) > 0 {
-		if err = os.WriteFile(outputFileName, content, 0644); err != nil {
-			panic(err)
-		}
+	return content, compressor.writer
+}
+
+func writeIndex(fileName string, index bgzf.Index) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
 	}
-	fmt.Printf("Original size (in bytes): %v\n", len(fileContent))
-	fmt.Printf("Compressed size (in bytes): %v\n", len(content))
-	fmt.Printf("Compression ratio: %.2f%%\n", float32(len(content))/float32(len(fileContent))*100)
-	return content
+	if _, err := index.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
 func (c *compression) init(params any) {
@@ -136,7 +262,15 @@ func (c *compression) init(params any) {
 	newReaderAndWriterFunc := compressionReaderAndWriters[c.compressionEngine]
 	switch c.compressionEngine {
 	case "huffman":
-		c.reader, c.writer = newReaderAndWriterFunc.(func() (io.ReadCloser, io.WriteCloser))()
+		if args, ok := params.(HuffmanArgs); ok && args.Adaptive {
+			c.reader, c.writer = huffman.NewAdaptiveCompressionReaderAndWriter()
+		} else if ok && args.Context {
+			c.reader, c.writer = huffman.NewContextCompressionReaderAndWriter()
+		} else if ok && args.ChunkSize > 0 {
+			c.reader, c.writer = huffman.NewChunkedCompressionReaderAndWriter(args.ChunkSize)
+		} else {
+			c.reader, c.writer = newReaderAndWriterFunc.(func() (io.ReadCloser, io.WriteCloser))()
+		}
 	case "lzss":
 		c.reader, c.writer = newReaderAndWriterFunc.(func(int, int) (io.ReadCloser, io.WriteCloser))(4096, 4096)
 	case "flate":
@@ -144,83 +278,242 @@ func (c *compression) init(params any) {
 			panic("arguments missing for flate")
 		} else {
 			// fmt.Printf("[ engine.compression.init ] case flate selected with args: %v\n", args)
-			c.reader, c.writer = newReaderAndWriterFunc.(func(uint32, uint32) (io.ReadCloser, io.WriteCloser))(args.Btype, args.Bfinal)
+			c.reader, c.writer = newFlateReaderAndWriter(args.Btype, args.Level, args.BlockSize, args.Threads, args.Rsyncable)
 		}
 	case "gzip":
 		if args, ok := params.(GzipArgs); !ok {
 			panic("arguments missing for gzip")
 		} else {
-			r, w := compressionReaderAndWriters["flate"].(func(uint32, uint32) (io.ReadCloser, io.WriteCloser))(args.Btype, args.Bfinal)
-			c.reader, c.writer = newReaderAndWriterFunc.(func(io.ReadCloser, io.WriteCloser) (io.ReadCloser, io.WriteCloser))(r, w)
+			r, w := newFlateReaderAndWriter(args.Btype, args.Level, args.BlockSize, args.Threads, args.Rsyncable)
+			c.reader, c.writer = newReaderAndWriterFunc.(func(io.ReadCloser, io.WriteCloser, int, gzip.Header) (io.ReadCloser, io.WriteCloser))(r, w, args.Level, args.Header)
+		}
+	case "zlib":
+		if args, ok := params.(ZlibArgs); !ok {
+			panic("arguments missing for zlib")
+		} else {
+			r, w := compressionReaderAndWriters["flate"].(func(uint32, int, int) (io.ReadCloser, io.WriteCloser))(args.Btype, args.Level, args.BlockSize)
+			c.reader, c.writer = newReaderAndWriterFunc.(func(io.ReadCloser, io.WriteCloser, int, []byte) (io.ReadCloser, io.WriteCloser))(r, w, args.Level, args.Dictionary)
+		}
+	case "bgzf":
+		if args, ok := params.(BgzfArgs); !ok {
+			panic("arguments missing for bgzf")
+		} else {
+			c.reader, c.writer = newReaderAndWriterFunc.(func(uint32, int) (io.ReadCloser, io.WriteCloser))(args.Btype, args.Level)
 		}
 	}
 }
 
-func DecompressFiles(algorithm string, files []string) {
+// newFlateReaderAndWriter picks the parallel flate writer when more than one
+// thread is asked for, its output decodes the same as the serial one.
+func newFlateReaderAndWriter(btype uint32, level int, blockSize int, threads int, rsyncable bool) (io.ReadCloser, io.WriteCloser) {
+	var r io.ReadCloser
+	var w io.WriteCloser
+	if threads > 1 {
+		r, w = flate.NewParallelCompressionReaderAndWriter(btype, level, blockSize, threads)
+	} else {
+		r, w = compressionReaderAndWriters["flate"].(func(uint32, int, int) (io.ReadCloser, io.WriteCloser))(btype, level, blockSize)
+	}
+	if rsyncable {
+		// both writers take it, and nothing has been written yet
+		w.(interface{ SetRsyncable(bool) error }).SetRsyncable(true)
+	}
+	return r, w
+}
+
+func DecompressFiles(algorithm string, files []string, args any) error {
 	// fmt.Printf("DecompresFiles function params: (algorithms, files): (%v, %v)\n", algorithms, files)
 	for _, file := range files {
-		decompressFile(algorithm, file)
+		if err := decompressFile(algorithm, file, args); err != nil {
+			return fmt.Errorf("could not decompress `%s`: %w", file, err)
+		}
 	}
+	return nil
 }
 
-func decompressFile(algorithm string, compressedFilePath string) {
+func decompressFile(algorithm string, compressedFilePath string, args any) error {
 	// outputFileName := strings.TrimSuffix(compressedFilePath, filepath.Ext(compressedFilePath))
 	outputFileName := strings.SplitN(compressedFilePath, ".", 2)[0]
 	outputFileName = outputFileName + "-decompressed" + ".txt"
-	fileContent, err := os.ReadFile(compressedFilePath)
-	// fmt.Printf("decompressFile function: compressFilePath: %v, outputFileName: %v, fileContent: %v\n", compressedFilePath, outputFileName, fileContent)
+	file, err := os.Open(compressedFilePath)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	defer file.Close()
+	output, err := os.Create(outputFileName)
+	if err != nil {
+		return err
 	}
 	fmt.Println("Decompressing...")
-	decompress(algorithm, fileContent, outputFileName)
+	if bgzfArgs, ok := args.(BgzfArgs); ok && (bgzfArgs.Offset > 0 || bgzfArgs.Length >= 0) {
+		err = extractRange(file, compressedFilePath+".gzi", output, bgzfArgs.Offset, bgzfArgs.Length)
+	} else {
+		_, err = decompress(algorithm, file, output, args)
+	}
+	if err != nil {
+		output.Close()
+		os.Remove(outputFileName)
+		return err
+	}
+	if err := output.Close(); err != nil {
+		return err
+	}
 	fmt.Printf("File `%s` has been decompressed into File `%s` into the current directory\n", compressedFilePath, outputFileName)
+	return nil
 }
 
-func ServerDecompress(algorithm string, reader io.Reader) io.ReadCloser {
-	if fileContent, err := io.ReadAll(reader); err != nil {
-		panic(err)
-	} else {
-		content := decompress(algorithm, fileContent, "")
-		pr, pw := io.Pipe()
-		go func() {
-			defer pw.Close()
-			io.Copy(pw, bytes.NewReader(content))
-		}()
-		return pr
+// extractRange decodes only the blocks covering the range, using the .gzi
+// index next to the file when there is one.
+func extractRange(file *os.File, indexFileName string, output io.Writer, offset, length int64) error {
+	var index bgzf.Index
+	if indexFile, err := os.Open(indexFileName); err == nil {
+		index, err = bgzf.ReadIndex(indexFile)
+		indexFile.Close()
+		if err != nil {
+			return fmt.Errorf("could not read the index `%s`: %w", indexFileName, err)
+		}
+	}
+	reader := bgzf.NewReader(file, index)
+	if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if length < 0 {
+		_, err := io.Copy(output, reader)
+		return err
 	}
+	// a range running past the end of the data stops at the end
+	if _, err := io.CopyN(output, reader, length); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
 }
 
-func decompress(algorithm string, fileContent []byte, outputFileName string) []byte {
+// RecoverFiles salvages what it can from damaged gzip files. The output is
+// kept even when parts of the input were lost, those parts are listed.
+func RecoverFiles(algorithm string, files []string) error {
+	if algorithm != "gzip" && algorithm != "bgzf" {
+		return fmt.Errorf("recovery is not supported for %v", algorithm)
+	}
+	damaged := 0
+	for _, file := range files {
+		if lost, err := recoverFile(file); err != nil {
+			return fmt.Errorf("could not recover `%s`: %w", file, err)
+		} else if lost {
+			damaged++
+		}
+	}
+	if damaged > 0 {
+		return fmt.Errorf("%v of %v files could only be partly recovered", damaged, len(files))
+	}
+	return nil
+}
+
+func recoverFile(compressedFilePath string) (bool, error) {
+	outputFileName := strings.SplitN(compressedFilePath, ".", 2)[0]
+	outputFileName = outputFileName + "-recovered" + ".txt"
+	data, err := os.ReadFile(compressedFilePath)
+	if err != nil {
+		return false, err
+	}
+	output, err := os.Create(outputFileName)
+	if err != nil {
+		return false, err
+	}
+	fmt.Println("Recovering...")
+	report, err := gzip.Recover(data, output)
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, lost := range report.Lost {
+		fmt.Printf("  decoding failed at byte %v bit %v: %v\n", lost.FailureBit/8, lost.FailureBit%8, lost.Err)
+		if lost.End < int64(len(data)) {
+			fmt.Printf("  lost compressed bytes %v-%v, resumed at byte %v of the output\n", lost.Start, lost.End, lost.Output)
+		} else {
+			fmt.Printf("  lost compressed bytes %v-%v, nothing decodable after byte %v of the output\n", lost.Start, lost.End, lost.Output)
+		}
+	}
+	for _, member := range report.Mismatched {
+		fmt.Printf("  member %v decoded but failed its crc or size check\n", member)
+	}
+	if report.Zeroed > 0 {
+		fmt.Printf("  %v bytes referred back into lost data and were written as zeros, later copies of them are zeros too\n", report.Zeroed)
+	}
+	fmt.Printf("%v bytes of `%s` have been recovered into File `%s`\n", report.Recovered, compressedFilePath, outputFileName)
+	return len(report.Lost) > 0 || len(report.Mismatched) > 0, nil
+}
+
+// TestFiles fully decodes every file, throwing the output away, and reports
+// each as OK or FAILED. Containers check their own checksums on the way.
+func TestFiles(algorithm string, files []string, args any) error {
+	failed := 0
+	for _, file := range files {
+		if err := testFile(algorithm, file, args); err != nil {
+			fmt.Printf("%s: FAILED, %v\n", file, err)
+			failed++
+		} else {
+			fmt.Printf("%s: OK\n", file)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v files failed the integrity test", failed, len(files))
+	}
+	return nil
+}
+
+func testFile(algorithm string, compressedFilePath string, args any) error {
+	file, err := os.Open(compressedFilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = decompress(algorithm, file, io.Discard, args)
+	return err
+}
+
+func ServerDecompress(algorithm string, reader io.Reader) io.ReadCloser {
 	decompressor := decompression{
 		decompressionEngine: algorithm,
 	}
-	decompressor.init()
-	var content []byte
-	var err error
+	decompressor.init(nil)
+	// the request body is fed in as it arrives, decompression errors surface on the returned reader
 	go func() {
-		if _, err = decompressor.writer.Write(fileContent); err != nil {
-			panic(err)
-		}
-		if err = decompressor.writer.Close(); err != nil {
-			panic(err)
-		}
+		defer decompressor.writer.Close()
+		io.Copy(decompressor.writer, reader)
 	}()
-	if content, err = io.ReadAll(decompressor.reader); err != nil {
-		panic(err)
-	}
-	if err = decompressor.reader.Close(); err != nil {
-		panic(err)
+	return decompressor.reader
+}
+
+func decompress(algorithm string, input io.Reader, output io.Writer, args any) (int64, error) {
+	decompressor := decompression{
+		decompressionEngine: algorithm,
 	}
-	if len(outputFileName) > 0 {
-		if err = os.WriteFile(outputFileName, content, 0666); err != nil {
-			panic(err)
+	decompressor.init(args)
+	writeErr := make(chan error, 1)
+	go func() {
+		// a decoder that trips over corrupt input must not take the process down
+		defer func() {
+			if r := recover(); r != nil {
+				writeErr <- fmt.Errorf("decoder failed on the compressed input: %v", r)
+			}
+		}()
+		if _, err := io.Copy(decompressor.writer, input); err != nil {
+			decompressor.writer.Close()
+			writeErr <- err
+			return
 		}
+		writeErr <- decompressor.writer.Close()
+	}()
+	n, err := io.Copy(output, decompressor.reader)
+	decompressor.reader.Close()
+	if writeErr := <-writeErr; err == nil {
+		err = writeErr
 	}
-	return content
+	return n, err
 }
 
-func (d *decompression) init() {
+func (d *decompression) init(params any) {
 	if !slices.Contains(Engines[:], d.decompressionEngine) {
 		fmt.Println("decompression engine does not exist")
 		os.Exit(1)
@@ -228,13 +521,26 @@ func (d *decompression) init() {
 	newReaderAndWriterFunc := decompressionReaderAndWriters[d.decompressionEngine]
 	switch d.decompressionEngine {
 	case "huffman":
-		d.reader, d.writer = newReaderAndWriterFunc.(func() (io.ReadCloser, io.WriteCloser))()
+		// the static decoder reads adaptive files too, this one streams them
+		if args, ok := params.(HuffmanArgs); ok && args.Adaptive {
+			d.reader, d.writer = huffman.NewAdaptiveDecompressionReaderAndWriter()
+		} else {
+			d.reader, d.writer = newReaderAndWriterFunc.(func() (io.ReadCloser, io.WriteCloser))()
+		}
 	case "lzss":
 		d.reader, d.writer = newReaderAndWriterFunc.(func() (io.ReadCloser, io.WriteCloser))()
 	case "flate":
 		d.reader, d.writer = newReaderAndWriterFunc.(func() (io.ReadCloser, io.WriteCloser))()
 	case "gzip":
-		r, w := decompressionReaderAndWriters["flate"].(func() (io.ReadCloser, io.WriteCloser))()
-		d.reader, d.writer = newReaderAndWriterFunc.(func(io.ReadCloser, io.WriteCloser) (io.ReadCloser, io.WriteCloser))(r, w)
+		d.reader, d.writer = newReaderAndWriterFunc.(func() (io.ReadCloser, io.WriteCloser))()
+	case "zlib":
+		// a preset dictionary is optional, streams that need one fail without it
+		var dictionary []byte
+		if args, ok := params.(ZlibArgs); ok {
+			dictionary = args.Dictionary
+		}
+		d.reader, d.writer = newReaderAndWriterFunc.(func([]byte) (io.ReadCloser, io.WriteCloser))(dictionary)
+	case "bgzf":
+		d.reader, d.writer = newReaderAndWriterFunc.(func() (io.ReadCloser, io.WriteCloser))()
 	}
 }
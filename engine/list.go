@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/FitrahHaque/Compression-Engine/compressor/flate"
+	"github.com/FitrahHaque/Compression-Engine/compressor/gzip"
+	"github.com/FitrahHaque/Compression-Engine/compressor/huffman"
+	"github.com/FitrahHaque/Compression-Engine/compressor/zlib"
+)
+
+var blockTypeNames = [...]string{"stored", "fixed", "dynamic", "reserved"}
+
+type listing struct {
+	method       string
+	checksum     uint32
+	modTime      time.Time
+	compressed   int64
+	uncompressed int64
+	name         string
+	blockTypes   []uint32
+}
+
+// ListFiles prints one line per gzip member, zlib stream or raw deflate
+// stream, like gzip -l. Every stream is decoded in full to find its block
+// types and to check it, the output is thrown away. An empty algorithm
+// picks the format from the first bytes of each file.
+func ListFiles(algorithm string, files []string) error {
+	failed := 0
+	fmt.Printf("%-7s %-8s  %-16s  %12s  %12s  %7s  %s\n", "method", "crc", "date", "compressed", "uncompressed", "ratio", "name")
+	for _, file := range files {
+		listings, err := listFile(algorithm, file)
+		for _, l := range listings {
+			l.print()
+		}
+		if err != nil {
+			fmt.Printf("%s: %v\n", file, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%v of %v files could not be listed", failed, len(files))
+	}
+	return nil
+}
+
+func (l listing) print() {
+	date := "-"
+	if !l.modTime.IsZero() {
+		date = l.modTime.Format("2006-01-02 15:04")
+	}
+	ratio := "-"
+	if l.uncompressed > 0 {
+		ratio = fmt.Sprintf("%.2f%%", float64(l.compressed)/float64(l.uncompressed)*100)
+	}
+	fmt.Printf("%-7s %08x  %-16s  %12d  %12d  %7s  %s\n", l.method, l.checksum, date, l.compressed, l.uncompressed, ratio, l.name)
+	fmt.Printf("%-7s blocks: %s\n", "", describeBlocks(l.blockTypes))
+}
+
+// describeBlocks names the btype of every block, folding runs: dynamic*12 stored
+func describeBlocks(blockTypes []uint32) string {
+	var runs []string
+	for i := 0; i < len(blockTypes); {
+		j := i
+		for j < len(blockTypes) && blockTypes[j] == blockTypes[i] {
+			j++
+		}
+		if j-i > 1 {
+			runs = append(runs, fmt.Sprintf("%s*%v", blockTypeNames[blockTypes[i]], j-i))
+		} else {
+			runs = append(runs, blockTypeNames[blockTypes[i]])
+		}
+		i = j
+	}
+	return strings.Join(runs, " ")
+}
+
+func listFile(algorithm string, filePath string) ([]listing, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	if len(algorithm) == 0 {
+		algorithm = detectFormat(reader)
+	}
+	br := huffman.NewBitReader(reader)
+	switch algorithm {
+	case "gzip", "bgzf":
+		return listGzip(br, filePath)
+	case "zlib":
+		if l, err := listZlib(br, filePath); err != nil {
+			return nil, err
+		} else {
+			return []listing{l}, nil
+		}
+	case "flate":
+		if l, err := listFlate(br, filePath); err != nil {
+			return nil, err
+		} else {
+			return []listing{l}, nil
+		}
+	default:
+		return nil, fmt.Errorf("listing is not supported for %v", algorithm)
+	}
+}
+
+// detectFormat tells gzip and zlib apart by their headers, anything else is
+// taken to be a raw deflate stream.
+func detectFormat(reader *bufio.Reader) string {
+	magic, _ := reader.Peek(2)
+	if len(magic) < 2 {
+		return "flate"
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		return "gzip"
+	}
+	if magic[0]&0x0f == 8 && magic[0]>>4 <= 7 && (uint16(magic[0])<<8|uint16(magic[1]))%31 == 0 {
+		return "zlib"
+	}
+	return "flate"
+}
+
+func listGzip(br *huffman.BitReader, filePath string) ([]listing, error) {
+	var listings []listing
+	for {
+		start := br.BitOffset() / 8
+		header, err := gzip.ReadHeader(br)
+		if len(listings) > 0 && errors.Is(err, gzip.ErrNotGzip) {
+			// trailing garbage, ignored the same way decompression does
+			return listings, nil
+		} else if err != nil {
+			return listings, err
+		}
+		l := listing{
+			method:  "gzip",
+			modTime: header.ModTime,
+			name:    header.Name,
+		}
+		if len(l.name) == 0 {
+			l.name = filePath
+		}
+		inflateReader := flate.NewInflateReader(br)
+		crc := crc32.NewIEEE()
+		l.uncompressed, err = io.Copy(crc, inflateReader)
+		l.blockTypes = inflateReader.BlockTypes()
+		if err != nil {
+			return listings, err
+		}
+		br.AlignToByte()
+		trailer := make([]byte, 8)
+		if err := br.ReadAlignedBytes(trailer); err != nil {
+			return listings, err
+		}
+		l.checksum = binary.LittleEndian.Uint32(trailer[0:4])
+		l.compressed = br.BitOffset()/8 - start
+		listings = append(listings, l)
+		if l.checksum != crc.Sum32() {
+			return listings, errors.New("crc did not match")
+		}
+		if binary.LittleEndian.Uint32(trailer[4:8]) != uint32(l.uncompressed) {
+			return listings, errors.New("size did not match")
+		}
+		if br.AtEOF() {
+			return listings, nil
+		}
+	}
+}
+
+func listZlib(br *huffman.BitReader, filePath string) (listing, error) {
+	l := listing{
+		method: "zlib",
+		name:   filePath,
+	}
+	header, err := zlib.ReadHeader(br)
+	if err != nil {
+		return l, err
+	}
+	if header.HasDictionary {
+		return l, fmt.Errorf("stream needs a preset dictionary with id %08x", header.DictionaryID)
+	}
+	inflateReader := flate.NewInflateReader(br)
+	adler := adler32.New()
+	l.uncompressed, err = io.Copy(adler, inflateReader)
+	l.blockTypes = inflateReader.BlockTypes()
+	if err != nil {
+		return l, err
+	}
+	br.AlignToByte()
+	trailer := make([]byte, 4)
+	if err := br.ReadAlignedBytes(trailer); err != nil {
+		return l, err
+	}
+	l.checksum = binary.BigEndian.Uint32(trailer)
+	l.compressed = br.BitOffset() / 8
+	if l.checksum != adler.Sum32() {
+		return l, errors.New("adler-32 checksum did not match")
+	}
+	return l, nil
+}
+
+// listFlate reports the CRC-32 of the decoded data since a raw deflate
+// stream carries no checksum of its own.
+func listFlate(br *huffman.BitReader, filePath string) (listing, error) {
+	l := listing{
+		method: "flate",
+		name:   filePath,
+	}
+	inflateReader := flate.NewInflateReader(br)
+	crc := crc32.NewIEEE()
+	var err error
+	l.uncompressed, err = io.Copy(crc, inflateReader)
+	l.blockTypes = inflateReader.BlockTypes()
+	l.checksum = crc.Sum32()
+	l.compressed = (br.BitOffset() + 7) / 8
+	return l, err
+}
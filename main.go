@@ -12,7 +12,7 @@ import (
 	"github.com/FitrahHaque/Compression-Engine/engine"
 )
 
-var Commands = [...]string{"compress", "decompress", "benchmark", "help", "server"}
+var Commands = [...]string{"compress", "decompress", "benchmark", "help", "server", "list", "test"}
 
 func main() {
 	application := os.Args[0]
@@ -21,6 +21,8 @@ func main() {
 	decompressCmd := flag.Bool(Commands[1], false, "Decompress File")
 	benchmarkCmd := flag.Bool(Commands[2], false, "Benchmark File")
 	serverCmd := flag.Bool(Commands[4], false, "Create a server")
+	listCmd := flag.Bool(Commands[5], false, "List the contents of compressed files")
+	testCmd := flag.Bool(Commands[6], false, "Test the integrity of compressed files")
 	helpCmd := flag.Bool(Commands[3], false, "Help")
 
 	if len(os.Args) == 1 {
@@ -33,11 +35,13 @@ func main() {
 			"--compress",
 			"--decompress",
 			"--benchmark",
+			"--list",
+			"--test",
 		},
 		os.Args[1:2],
 	)
 	flag.CommandLine.Parse(commandArgs)
-	commandsSelected := countTrue([]bool{*compressCmd, *decompressCmd, *benchmarkCmd, *serverCmd})
+	commandsSelected := countTrue([]bool{*compressCmd, *decompressCmd, *benchmarkCmd, *serverCmd, *listCmd, *testCmd})
 	if commandsSelected > 1 {
 		fmt.Println("Specify a single command")
 		os.Exit(1)
@@ -63,6 +67,8 @@ func main() {
 	checkForCompress(application, "", compressCmd, 1)
 	checkForDecompress(application, "", decompressCmd, 1)
 	checkForServer(application, "", serverCmd, 1)
+	checkForList(application, "", listCmd, 1)
+	checkForTest(application, "", testCmd, 1)
 	// var generateHTML *bool
 	// if *benchmarkCmd {
 	// 	generateHTML = flag.Bool("generate", false, "Compile benchmark results as an html file")
@@ -81,24 +87,76 @@ func countTrue(commands []bool) int {
 
 func checkForAlgorithm(application, prefix string, algorithmChosen *string, algorithmIdx int) any {
 	var args any
+	if *algorithmChosen == "huffman" {
+		huffmanFS := flag.NewFlagSet("huffman", flag.ExitOnError)
+		huffmanFS.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage of %s %s --algorithm=huffman [OPTIONS] <file(s)>\n", application, prefix)
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"adaptive, context, chunksize, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Flag:\n")
+			huffmanFS.PrintDefaults()
+		}
+		adaptiveHuffman := huffmanFS.Bool("adaptive", false, "Code in one pass with a tree that adapts after every byte, no frequency table and no buffering")
+		contextHuffman := huffmanFS.Bool("context", false, "Keep a code table for every previous byte (order-1), better on structured text such as CSV or JSON")
+		chunkSizeHuffman := huffmanFS.Int("chunksize", 0, "Code the input in chunks of this many bytes, at least 1024, with a table each. 0 uses one table for the whole file")
+		helpHuffman := huffmanFS.Bool("help", false, "Help")
+		commandArgs := findIntersection(
+			[]string{
+				"--adaptive",
+				"--context",
+				"--chunksize",
+			},
+			os.Args[algorithmIdx+1:],
+		)
+		if len(commandArgs) == 0 {
+			commandArgs = findIntersection(
+				[]string{
+					"--help",
+				},
+				os.Args[algorithmIdx+1:],
+			)
+		}
+		huffmanFS.Parse(commandArgs)
+		if *helpHuffman {
+			huffmanFS.Usage()
+		}
+		if countTrue([]bool{*adaptiveHuffman, *contextHuffman, *chunkSizeHuffman > 0}) > 1 {
+			fmt.Println("Choose one of --adaptive, --context and --chunksize")
+			os.Exit(1)
+		}
+		if *chunkSizeHuffman != 0 && *chunkSizeHuffman < 1024 {
+			fmt.Println("Chunk size must be at least 1024")
+			os.Exit(1)
+		}
+		args = engine.HuffmanArgs{
+			Adaptive:  *adaptiveHuffman,
+			Context:   *contextHuffman,
+			ChunkSize: *chunkSizeHuffman,
+		}
+	}
 	if *algorithmChosen == "flate" {
 		// fmt.Printf("[ main ] flate algorithm chosen\n")
 		flateCompressFS := flag.NewFlagSet("flate", flag.ExitOnError)
 		flateCompressFS.Usage = func() {
 			fmt.Fprintf(os.Stderr, "Usage of %s %s --algorithm=flate [OPTIONS] <file(s)>\n", application, prefix)
-			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"btype, bfinal, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"btype, blocksize, level, threads, rsyncable, help"}, ", "))
 			fmt.Fprintf(os.Stderr, "Flag:\n")
 			flateCompressFS.PrintDefaults()
 		}
-		btypeFlateCompress := flateCompressFS.Int("btype", 2, "Which btype to use, choices include: 1, 2, 3")
-		bfinalFlateCompress := flateCompressFS.Int("bfinal", 0, "Final Block of the compression process")
+		btypeFlateCompress := flateCompressFS.Int("btype", 2, "Which btype to use, choices include: 0, 1, 2")
+		blockSizeFlateCompress := flateCompressFS.Int("blocksize", 65536, "Number of input bytes covered by each DEFLATE block")
+		levelFlateCompress := flateCompressFS.Int("level", 6, "Compression level from 0 (stored, no compression) to 9 (best ratio), 1 is the fastest")
+		threadsFlateCompress := flateCompressFS.Int("threads", 1, "Number of chunks of the input to compress at once")
+		rsyncableFlateCompress := flateCompressFS.Bool("rsyncable", false, "End blocks at content-defined boundaries so small edits to the input only change the output locally")
 		helpFlateCompress := flateCompressFS.Bool("help", false, "Compress Help")
 		commandArgs := findIntersection(
 			[]string{
 				"--btype",
-				"--bfinal",
+				"--blocksize",
+				"--level",
+				"--threads",
+				"--rsyncable",
 			},
-			os.Args[algorithmIdx+2:],
+			os.Args[algorithmIdx+1:],
 		)
 		// fmt.Println(commandArgs)
 		if len(commandArgs) == 0 {
@@ -106,16 +164,27 @@ func checkForAlgorithm(application, prefix string, algorithmChosen *string, algo
 				[]string{
 					"--help",
 				},
-				os.Args[algorithmIdx+2:],
+				os.Args[algorithmIdx+1:],
 			)
 		}
 		flateCompressFS.Parse(commandArgs)
 		if *helpFlateCompress {
 			flateCompressFS.Usage()
 		}
+		if *btypeFlateCompress < 0 || *btypeFlateCompress > 2 {
+			fmt.Println("Btype must be 0, 1 or 2")
+			os.Exit(1)
+		}
+		if *levelFlateCompress < 0 || *levelFlateCompress > 9 {
+			fmt.Println("Compression level must be between 0 and 9")
+			os.Exit(1)
+		}
 		args = engine.FlateArgs{
-			Btype:  uint32(*btypeFlateCompress),
-			Bfinal: uint32(*bfinalFlateCompress),
+			Btype:     uint32(*btypeFlateCompress),
+			Level:     *levelFlateCompress,
+			BlockSize: *blockSizeFlateCompress,
+			Threads:   *threadsFlateCompress,
+			Rsyncable: *rsyncableFlateCompress,
 		}
 	}
 	if *algorithmChosen == "gzip" {
@@ -123,19 +192,33 @@ func checkForAlgorithm(application, prefix string, algorithmChosen *string, algo
 		gzipCompressFS := flag.NewFlagSet("gzip", flag.ExitOnError)
 		gzipCompressFS.Usage = func() {
 			fmt.Fprintf(os.Stderr, "Usage of %s --compress --algorithm=gzip [OPTIONS] <file(s)>\n", application)
-			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"btype, bfinal, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"btype, blocksize, level, threads, rsyncable, name, no-name, comment, append, help"}, ", "))
 			fmt.Fprintf(os.Stderr, "Flag:\n")
 			gzipCompressFS.PrintDefaults()
 		}
-		btypeGzipCompress := gzipCompressFS.Int("btype", 2, "Which btype to use, choices include: 1, 2, 3")
-		bfinalGzipCompress := gzipCompressFS.Int("bfinal", 0, "Final Block of the compression process")
+		btypeGzipCompress := gzipCompressFS.Int("btype", 2, "Which btype to use, choices include: 0, 1, 2")
+		blockSizeGzipCompress := gzipCompressFS.Int("blocksize", 65536, "Number of input bytes covered by each DEFLATE block")
+		levelGzipCompress := gzipCompressFS.Int("level", 6, "Compression level from 0 (stored, no compression) to 9 (best ratio), 1 is the fastest")
+		threadsGzipCompress := gzipCompressFS.Int("threads", 1, "Number of chunks of the input to compress at once")
+		rsyncableGzipCompress := gzipCompressFS.Bool("rsyncable", false, "End blocks at content-defined boundaries so small edits to the input only change the output locally")
+		nameGzipCompress := gzipCompressFS.Bool("name", true, "Save the original file name and modification time in the header")
+		noNameGzipCompress := gzipCompressFS.Bool("no-name", false, "Do not save the original file name and modification time")
+		commentGzipCompress := gzipCompressFS.String("comment", "", "Comment to store in the header")
+		appendGzipCompress := gzipCompressFS.Bool("append", false, "Add a new member to the end of an existing output file instead of replacing it")
 		helpGzipCompress := gzipCompressFS.Bool("help", false, "Compress Help")
 		commandArgs := findIntersection(
 			[]string{
 				"--btype",
-				"--bfinal",
+				"--blocksize",
+				"--level",
+				"--threads",
+				"--rsyncable",
+				"--name",
+				"--no-name",
+				"--comment",
+				"--append",
 			},
-			os.Args[algorithmIdx+2:],
+			os.Args[algorithmIdx+1:],
 		)
 		// fmt.Println(commandArgs)
 		if len(commandArgs) == 0 {
@@ -143,16 +226,142 @@ func checkForAlgorithm(application, prefix string, algorithmChosen *string, algo
 				[]string{
 					"--help",
 				},
-				os.Args[algorithmIdx+2:],
+				os.Args[algorithmIdx+1:],
 			)
 		}
 		gzipCompressFS.Parse(commandArgs)
 		if *helpGzipCompress {
 			gzipCompressFS.Usage()
 		}
-		args = engine.GzipArgs{
-			Btype:  uint32(*btypeGzipCompress),
-			Bfinal: uint32(*bfinalGzipCompress),
+		if *btypeGzipCompress < 0 || *btypeGzipCompress > 2 {
+			fmt.Println("Btype must be 0, 1 or 2")
+			os.Exit(1)
+		}
+		if *levelGzipCompress < 0 || *levelGzipCompress > 9 {
+			fmt.Println("Compression level must be between 0 and 9")
+			os.Exit(1)
+		}
+		gzipArgs := engine.GzipArgs{
+			Btype:     uint32(*btypeGzipCompress),
+			Level:     *levelGzipCompress,
+			BlockSize: *blockSizeGzipCompress,
+			Threads:   *threadsGzipCompress,
+			Rsyncable: *rsyncableGzipCompress,
+			NoName:    *noNameGzipCompress || !*nameGzipCompress,
+			Append:    *appendGzipCompress,
+		}
+		gzipArgs.Header.Comment = *commentGzipCompress
+		args = gzipArgs
+	}
+	if *algorithmChosen == "zlib" {
+		zlibCompressFS := flag.NewFlagSet("zlib", flag.ExitOnError)
+		zlibCompressFS.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage of %s %s --algorithm=zlib [OPTIONS] <file(s)>\n", application, prefix)
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"btype, blocksize, level, dictionary, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Flag:\n")
+			zlibCompressFS.PrintDefaults()
+		}
+		btypeZlibCompress := zlibCompressFS.Int("btype", 2, "Which btype to use, choices include: 0, 1, 2")
+		blockSizeZlibCompress := zlibCompressFS.Int("blocksize", 65536, "Number of input bytes covered by each DEFLATE block")
+		levelZlibCompress := zlibCompressFS.Int("level", 6, "Compression level from 0 (stored, no compression) to 9 (best ratio), 1 is the fastest")
+		dictionaryZlibCompress := zlibCompressFS.String("dictionary", "", "File holding the preset dictionary (FDICT), needed again to decompress")
+		helpZlibCompress := zlibCompressFS.Bool("help", false, "Compress Help")
+		commandArgs := findIntersection(
+			[]string{
+				"--btype",
+				"--blocksize",
+				"--level",
+				"--dictionary",
+			},
+			os.Args[algorithmIdx+1:],
+		)
+		if len(commandArgs) == 0 {
+			commandArgs = findIntersection(
+				[]string{
+					"--help",
+				},
+				os.Args[algorithmIdx+1:],
+			)
+		}
+		zlibCompressFS.Parse(commandArgs)
+		if *helpZlibCompress {
+			zlibCompressFS.Usage()
+		}
+		if *btypeZlibCompress < 0 || *btypeZlibCompress > 2 {
+			fmt.Println("Btype must be 0, 1 or 2")
+			os.Exit(1)
+		}
+		if *levelZlibCompress < 0 || *levelZlibCompress > 9 {
+			fmt.Println("Compression level must be between 0 and 9")
+			os.Exit(1)
+		}
+		var dictionary []byte
+		if len(*dictionaryZlibCompress) > 0 {
+			if content, err := os.ReadFile(*dictionaryZlibCompress); err != nil {
+				fmt.Printf("Could not open the provided dictionary %s\n", *dictionaryZlibCompress)
+				os.Exit(1)
+			} else {
+				dictionary = content
+			}
+		}
+		args = engine.ZlibArgs{
+			Btype:      uint32(*btypeZlibCompress),
+			Level:      *levelZlibCompress,
+			BlockSize:  *blockSizeZlibCompress,
+			Dictionary: dictionary,
+		}
+	}
+	if *algorithmChosen == "bgzf" {
+		bgzfFS := flag.NewFlagSet("bgzf", flag.ExitOnError)
+		bgzfFS.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage of %s %s --algorithm=bgzf [OPTIONS] <file(s)>\n", application, prefix)
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"btype, level, offset, length, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Flag:\n")
+			bgzfFS.PrintDefaults()
+		}
+		btypeBgzf := bgzfFS.Int("btype", 2, "Which btype to use, choices include: 0, 1, 2")
+		levelBgzf := bgzfFS.Int("level", 6, "Compression level from 0 (stored, no compression) to 9 (best ratio), 1 is the fastest")
+		offsetBgzf := bgzfFS.Int64("offset", 0, "Decompress starting from this uncompressed offset, decoding only the blocks needed")
+		lengthBgzf := bgzfFS.Int64("length", -1, "Number of bytes to decompress from the offset, -1 runs to the end")
+		helpBgzf := bgzfFS.Bool("help", false, "Help")
+		commandArgs := findIntersection(
+			[]string{
+				"--btype",
+				"--level",
+				"--offset",
+				"--length",
+			},
+			os.Args[algorithmIdx+1:],
+		)
+		if len(commandArgs) == 0 {
+			commandArgs = findIntersection(
+				[]string{
+					"--help",
+				},
+				os.Args[algorithmIdx+1:],
+			)
+		}
+		bgzfFS.Parse(commandArgs)
+		if *helpBgzf {
+			bgzfFS.Usage()
+		}
+		if *btypeBgzf < 0 || *btypeBgzf > 2 {
+			fmt.Println("Btype must be 0, 1 or 2")
+			os.Exit(1)
+		}
+		if *levelBgzf < 0 || *levelBgzf > 9 {
+			fmt.Println("Compression level must be between 0 and 9")
+			os.Exit(1)
+		}
+		if *offsetBgzf < 0 {
+			fmt.Println("Offset cannot be negative")
+			os.Exit(1)
+		}
+		args = engine.BgzfArgs{
+			Btype:  uint32(*btypeBgzf),
+			Level:  *levelBgzf,
+			Offset: *offsetBgzf,
+			Length: *lengthBgzf,
 		}
 	}
 	return args
@@ -191,19 +400,23 @@ func checkForCompress(application string, prefix string, compressCmd *bool, comp
 		compressFS := flag.NewFlagSet("compress", flag.ExitOnError)
 		compressFS.Usage = func() {
 			fmt.Fprintf(os.Stderr, "Usage of %s --compress [OPTIONS] <file(s)>\n", application)
-			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"algorithm, delete, outfileext, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"algorithm, delete, outfileext, determinism, runs, help"}, ", "))
 			fmt.Fprintf(os.Stderr, "Flag:\n")
 			compressFS.PrintDefaults()
 		}
 		algorithmCompress := compressFS.String("algorithm", "huffman", fmt.Sprintf("Which algorithm(s) to use, choices include: \n\t%s", strings.Join(engine.Engines[:], ", ")))
 		deleteAfterCompress := compressFS.Bool("delete", false, "Delete file after compression")
 		outputFileExtensionCompress := compressFS.String("outfileext", ".shk", "File extension used for the result")
+		determinismCompress := compressFS.Bool("determinism", false, "Compress each file several times in memory and check every run gives the same bytes, nothing is written")
+		runsCompress := compressFS.Int("runs", 5, "Number of runs to compare with --determinism")
 		helpCompress := compressFS.Bool("help", false, "Compress Help")
 		commandArgs := findIntersection(
 			[]string{
 				"--algorithm",
 				"--delete",
 				"--outfileext",
+				"--determinism",
+				"--runs",
 			},
 			os.Args[compressIdx+1:],
 		)
@@ -227,6 +440,13 @@ func checkForCompress(application string, prefix string, compressCmd *bool, comp
 		// engine.CompressFiles(algorithmsChosen, files, *outputFileExtensionCompress)
 		subPrefix := strings.Join([]string{prefix, fmt.Sprintf("--%s", "compress")}, " ")
 		args := checkForAlgorithm(application, subPrefix, algorithmCompress, compressIdx+1)
+		if *determinismCompress {
+			if err := engine.CheckDeterminism(*algorithmCompress, files, *runsCompress, args); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
 
 		engine.CompressFiles(*algorithmCompress, files, *outputFileExtensionCompress, args)
 		if *deleteAfterCompress {
@@ -241,17 +461,19 @@ func checkForDecompress(application string, prefix string, decompressCmd *bool,
 		decompressFS := flag.NewFlagSet("decompress", flag.ExitOnError)
 		decompressFS.Usage = func() {
 			fmt.Fprintf(os.Stderr, "Usage of %s %s --decompress [OPTIONS] <file(s)>\n", application, prefix)
-			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"algorithm, delete, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"algorithm, delete, recover, help"}, ", "))
 			fmt.Fprintf(os.Stderr, "Flag:\n")
 			decompressFS.PrintDefaults()
 		}
 		deleteAfterDecompress := decompressFS.Bool("delete", false, "Delete compression file after decompression")
+		recoverDecompress := decompressFS.Bool("recover", false, "Write out whatever can be decoded from a damaged gzip file and list the lost ranges")
 		algorithmDecompress := decompressFS.String("algorithm", "huffman", fmt.Sprintf("Which algorithm(s) to use, choices include: \n\t%s", strings.Join(engine.Engines[:], ", ")))
 		helpDecompress := decompressFS.Bool("help", false, "Help")
 		commandArgs := findIntersection(
 			[]string{
 				"--algorithm",
 				"--delete",
+				"--recover",
 				"--help",
 			},
 			os.Args[decompressIdx+1:],
@@ -270,16 +492,90 @@ func checkForDecompress(application string, prefix string, decompressCmd *bool,
 			decompressFS.Usage()
 		}
 		files := checkForFiles(decompressIdx)
+		if *recoverDecompress {
+			if err := engine.RecoverFiles(*algorithmDecompress, files); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		}
 		// algorithmsChosen := strings.Split(*algorithmDecompress, ",")
 		// trimSpace(algorithmsChosen)
 		// engine.DecompressFiles(algorithmsChosen, files)
-		engine.DecompressFiles(*algorithmDecompress, files)
+		subPrefix := strings.Join([]string{prefix, fmt.Sprintf("--%s", "decompress")}, " ")
+		args := checkForAlgorithm(application, subPrefix, algorithmDecompress, decompressIdx+1)
+		if err := engine.DecompressFiles(*algorithmDecompress, files, args); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		if *deleteAfterDecompress {
 			deleteFiles(files)
 		}
 	}
 }
 
+func checkForList(application string, prefix string, listCmd *bool, listIdx int) {
+	if *listCmd {
+		listFS := flag.NewFlagSet("list", flag.ExitOnError)
+		listFS.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage of %s %s --list [OPTIONS] <file(s)>\n", application, prefix)
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"algorithm, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Flag:\n")
+			listFS.PrintDefaults()
+		}
+		algorithmList := listFS.String("algorithm", "", "Container of the files, choices include: gzip, zlib, flate, bgzf. Detected from the file when empty")
+		helpList := listFS.Bool("help", false, "Help")
+		commandArgs := findIntersection(
+			[]string{
+				"--algorithm",
+				"--help",
+			},
+			os.Args[listIdx+1:],
+		)
+		listFS.Parse(commandArgs)
+		if *helpList {
+			listFS.Usage()
+		}
+		files := checkForFiles(listIdx)
+		if err := engine.ListFiles(*algorithmList, files); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+func checkForTest(application string, prefix string, testCmd *bool, testIdx int) {
+	if *testCmd {
+		testFS := flag.NewFlagSet("test", flag.ExitOnError)
+		testFS.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage of %s %s --test [OPTIONS] <file(s)>\n", application, prefix)
+			fmt.Fprintf(os.Stderr, "Valid commands include:\n\t%s\n", strings.Join([]string{"algorithm, help"}, ", "))
+			fmt.Fprintf(os.Stderr, "Flag:\n")
+			testFS.PrintDefaults()
+		}
+		algorithmTest := testFS.String("algorithm", "huffman", fmt.Sprintf("Which algorithm(s) to use, choices include: \n\t%s", strings.Join(engine.Engines[:], ", ")))
+		helpTest := testFS.Bool("help", false, "Help")
+		commandArgs := findIntersection(
+			[]string{
+				"--algorithm",
+				"--help",
+			},
+			os.Args[testIdx+1:],
+		)
+		testFS.Parse(commandArgs)
+		if *helpTest {
+			testFS.Usage()
+		}
+		files := checkForFiles(testIdx)
+		subPrefix := strings.Join([]string{prefix, fmt.Sprintf("--%s", "test")}, " ")
+		args := checkForAlgorithm(application, subPrefix, algorithmTest, testIdx+1)
+		if err := engine.TestFiles(*algorithmTest, files, args); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
 func checkForServer(application string, prefix string, serverCmd *bool, serverIdx int) {
 	if *serverCmd {
 		serverFS := flag.NewFlagSet("server", flag.ExitOnError)
@@ -382,6 +678,10 @@ func compressionMiddleware(handler http.Handler) http.Handler {
 			handler.ServeHTTP(w, r)
 			return
 		} else {
+			// HTTP's "deflate" coding is the zlib wrapper, not a raw deflate stream
+			if value == "deflate" {
+				value = "zlib"
+			}
 			r.Body = engine.ServerDecompress(value, r.Body)
 			handler.ServeHTTP(w, r)
 		}